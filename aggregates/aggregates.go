@@ -0,0 +1,134 @@
+package aggregates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	FormatConcat = "concat"
+	FormatEnv    = "env"
+	FormatJson   = "json"
+	FormatYaml   = "yaml"
+)
+
+/*
+File generated from all the keys under a sub-prefix.
+The keys under the sub-prefix are consumed by the aggregate and not written as files themselves.
+*/
+type Aggregate struct {
+	//Path of the generated file relative to the directory
+	File string
+	//Sub-prefix of the keys the file is generated from, relative to the prefix
+	Prefix string
+	Format string
+	//Separator between values for the "concat" format
+	Separator string
+}
+
+func getEnvName(key string) string {
+	return strings.ReplaceAll(key, "/", "_")
+}
+
+func getEnvValue(val string) string {
+	if !strings.ContainsAny(val, " \t\n\"'\\$#`") {
+		return val
+	}
+
+	replacer := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "$", "\\$", "`", "\\`")
+	return "\"" + replacer.Replace(val) + "\""
+}
+
+/*
+Generates the content of the aggregate from its keys, relative to its sub-prefix
+*/
+func (agg *Aggregate) Render(keys map[string]string) (string, error) {
+	names := []string{}
+	for name, _ := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch agg.Format {
+	case FormatConcat:
+		values := []string{}
+		for _, name := range names {
+			values = append(values, keys[name])
+		}
+		return strings.Join(values, agg.Separator), nil
+	case FormatEnv:
+		var output strings.Builder
+		for _, name := range names {
+			output.WriteString(fmt.Sprintf("%s=%s\n", getEnvName(name), getEnvValue(keys[name])))
+		}
+		return output.String(), nil
+	case FormatJson:
+		output, err := json.MarshalIndent(keys, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(output) + "\n", nil
+	case FormatYaml:
+		output, err := yaml.Marshal(keys)
+		if err != nil {
+			return "", err
+		}
+		return string(output), nil
+	default:
+		return "", errors.New(fmt.Sprintf("Unsupported aggregate format '%s'", agg.Format))
+	}
+}
+
+/*
+Replaces the keys under the sub-prefixes of the aggregates with the files generated from them.
+An aggregate is pending if any of its keys is pending.
+*/
+func ApplyAggregates(values map[string]string, pending map[string]bool, aggs []Aggregate) (map[string]string, map[string]bool, error) {
+	result := map[string]string{}
+	for key, val := range values {
+		result[key] = val
+	}
+
+	resultPending := map[string]bool{}
+	for key, _ := range pending {
+		resultPending[key] = true
+	}
+
+	outputs := map[string]string{}
+	for _, agg := range aggs {
+		keys := map[string]string{}
+		for key, val := range values {
+			if strings.HasPrefix(key, agg.Prefix) {
+				keys[strings.TrimPrefix(key, agg.Prefix)] = val
+				delete(result, key)
+			}
+		}
+
+		for key, _ := range pending {
+			if strings.HasPrefix(key, agg.Prefix) {
+				resultPending[agg.File] = true
+				delete(resultPending, key)
+			}
+		}
+
+		output, err := agg.Render(keys)
+		if err != nil {
+			return result, resultPending, errors.New(fmt.Sprintf("Error generating aggregate file %s: %s", agg.File, err.Error()))
+		}
+		outputs[agg.File] = output
+	}
+
+	for file, output := range outputs {
+		if _, ok := result[file]; ok {
+			return result, resultPending, errors.New(fmt.Sprintf("Aggregate file %s conflicts with a key of the same path", file))
+		}
+		result[file] = output
+	}
+
+	return result, resultPending, nil
+}
@@ -0,0 +1,157 @@
+package aggregates
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	keys := map[string]string{"b": "2", "a": "1", "dir/c": "3"}
+
+	tests := []struct {
+		name     string
+		agg      Aggregate
+		keys     map[string]string
+		expected string
+		valid    bool
+	}{
+		{"concat sorted by key", Aggregate{Format: FormatConcat, Separator: ","}, keys, "1,2,3", true},
+		{"env", Aggregate{Format: FormatEnv}, keys, "a=1\nb=2\ndir_c=3\n", true},
+		{"json", Aggregate{Format: FormatJson}, map[string]string{"a": "1"}, "{\n  \"a\": \"1\"\n}\n", true},
+		{"yaml", Aggregate{Format: FormatYaml}, map[string]string{"a": "1"}, "a: \"1\"\n", true},
+		{"no keys", Aggregate{Format: FormatEnv}, map[string]string{}, "", true},
+		{"unsupported format", Aggregate{Format: "xml"}, keys, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := test.agg.Render(test.keys)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("Render() = %q, expected an error", output)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if output != test.expected {
+				t.Errorf("Render() = %q, expected %q", output, test.expected)
+			}
+		})
+	}
+}
+
+func TestRenderEnvQuoting(t *testing.T) {
+	tests := []struct {
+		name     string
+		val      string
+		expected string
+	}{
+		{"plain value", "value", "KEY=value\n"},
+		{"empty value", "", "KEY=\n"},
+		{"space", "a b", "KEY=\"a b\"\n"},
+		{"tab", "a\tb", "KEY=\"a\tb\"\n"},
+		{"newline", "a\nb", "KEY=\"a\\nb\"\n"},
+		{"double quote", `a"b`, `KEY="a\"b"` + "\n"},
+		{"single quote", "a'b", "KEY=\"a'b\"\n"},
+		{"backslash", `a\b`, `KEY="a\\b"` + "\n"},
+		{"variable expansion", "$HOME", `KEY="\$HOME"` + "\n"},
+		{"command substitution", "`id`", "KEY=\"\\`id\\`\"\n"},
+		{"comment", "a#b", "KEY=\"a#b\"\n"},
+	}
+
+	agg := Aggregate{Format: FormatEnv}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := agg.Render(map[string]string{"KEY": test.val})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if output != test.expected {
+				t.Errorf("Render(%q) = %q, expected %q", test.val, output, test.expected)
+			}
+		})
+	}
+}
+
+func TestApplyAggregates(t *testing.T) {
+	aggs := []Aggregate{{File: "app.env", Prefix: "env/", Format: FormatEnv}}
+
+	tests := []struct {
+		name            string
+		values          map[string]string
+		pending         map[string]bool
+		aggs            []Aggregate
+		expected        map[string]string
+		expectedPending map[string]bool
+		valid           bool
+	}{
+		{
+			name:            "keys replaced by the aggregate",
+			values:          map[string]string{"env/A": "1", "env/B": "2", "app.conf": "app"},
+			pending:         map[string]bool{},
+			aggs:            aggs,
+			expected:        map[string]string{"app.env": "A=1\nB=2\n", "app.conf": "app"},
+			expectedPending: map[string]bool{},
+			valid:           true,
+		},
+		{
+			name:            "aggregate without keys",
+			values:          map[string]string{"app.conf": "app"},
+			pending:         map[string]bool{},
+			aggs:            aggs,
+			expected:        map[string]string{"app.env": "", "app.conf": "app"},
+			expectedPending: map[string]bool{},
+			valid:           true,
+		},
+		{
+			name:            "aggregate with a pending key is pending",
+			values:          map[string]string{"env/A": "1"},
+			pending:         map[string]bool{"env/B": true, "other.conf": true},
+			aggs:            aggs,
+			expected:        map[string]string{"app.env": "A=1\n"},
+			expectedPending: map[string]bool{"app.env": true, "other.conf": true},
+			valid:           true,
+		},
+		{
+			name:    "aggregate conflicting with a key",
+			values:  map[string]string{"env/A": "1", "app.env": "app"},
+			pending: map[string]bool{},
+			aggs:    aggs,
+		},
+		{
+			name:    "unsupported format",
+			values:  map[string]string{},
+			pending: map[string]bool{},
+			aggs:    []Aggregate{{File: "app.xml", Prefix: "xml/", Format: "xml"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, pending, err := ApplyAggregates(test.values, test.pending, test.aggs)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("ApplyAggregates() = %v, expected an error", result)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("ApplyAggregates() values = %v, expected %v", result, test.expected)
+			}
+
+			if !reflect.DeepEqual(pending, test.expectedPending) {
+				t.Errorf("ApplyAggregates() pending = %v, expected %v", pending, test.expectedPending)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package archives
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/filesystem"
+)
+
+const (
+	TarSuffix   = ".tar"
+	TarGzSuffix = ".tar.gz"
+	TgzSuffix   = ".tgz"
+)
+
+const (
+	DefaultMaxEntrySize = 64 * 1024 * 1024
+	DefaultMaxTotalSize = 256 * 1024 * 1024
+	DefaultMaxEntries   = 10000
+)
+
+/*
+Limits on the content of an archive, to protect against archives that expand to more data or files than the host can hold
+*/
+type Limits struct {
+	MaxEntrySize int64
+	MaxTotalSize int64
+	MaxEntries   int64
+}
+
+/*
+Returns the directory an archive key should be extracted to and whether the archive is gzip compressed, based on the key's suffix.
+The last return value is false if the key is not an archive.
+*/
+func GetArchiveDirectory(key string) (string, bool, bool) {
+	for _, suffix := range []string{TarGzSuffix, TgzSuffix} {
+		if strings.HasSuffix(key, suffix) && len(key) > len(suffix) {
+			return strings.TrimSuffix(key, suffix), true, true
+		}
+	}
+
+	if strings.HasSuffix(key, TarSuffix) && len(key) > len(TarSuffix) {
+		return strings.TrimSuffix(key, TarSuffix), false, true
+	}
+
+	return "", false, false
+}
+
+/*
+Extracts the regular files of a tar archive, optionally gzip compressed.
+Returns their content keyed by their path in the archive with forward slashes.
+Directories are implied by the files they contain and an error is returned for links, special files and paths that are absolute or go outside of the archive's root.
+An error is also returned if the archive exceeds any of the limits.
+*/
+func Extract(archive string, compressed bool, limits Limits) (map[string]string, error) {
+	var reader io.Reader = strings.NewReader(archive)
+	if compressed {
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error decompressing archive: %s", err.Error()))
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+
+	files := map[string]string{}
+	entries := int64(0)
+	totalSize := int64(0)
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error reading archive: %s", err.Error()))
+		}
+
+		entries++
+		if entries > limits.MaxEntries {
+			return nil, errors.New(fmt.Sprintf("Archive has more than the maximum of %d entries", limits.MaxEntries))
+		}
+
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return nil, errors.New(fmt.Sprintf("Archive entry %s is not a regular file or a directory", header.Name))
+		}
+
+		name := path.Clean(strings.ReplaceAll(header.Name, "\\", "/"))
+		if path.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, errors.New(fmt.Sprintf("Archive entry %s resolves outside of the archive's directory", header.Name))
+		}
+
+		if header.Size > limits.MaxEntrySize {
+			return nil, errors.New(fmt.Sprintf("Archive entry %s exceeds the maximum entry size of %d bytes", header.Name, limits.MaxEntrySize))
+		}
+
+		totalSize += header.Size
+		if totalSize > limits.MaxTotalSize {
+			return nil, errors.New(fmt.Sprintf("Archive exceeds the maximum total size of %d bytes", limits.MaxTotalSize))
+		}
+
+		var content strings.Builder
+		_, err = io.Copy(&content, io.LimitReader(tarReader, header.Size))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error reading archive entry %s: %s", header.Name, err.Error()))
+		}
+
+		files[name] = content.String()
+	}
+
+	return files, nil
+}
+
+type archiveValue struct {
+	Dir        string
+	Compressed bool
+	Content    string
+	Envelope   *filesystem.Envelope
+}
+
+/*
+Returns the archive carried by a key, or nil if the key is not an archive.
+Envelopes marking their content as an archive determine the compression and the directory, which is named after the key.
+*/
+func getArchive(key string, value string, envelopes bool, maxDecodedSize int64) (*archiveValue, error) {
+	dir, compressed, isArchive := GetArchiveDirectory(key)
+	archive := archiveValue{Dir: dir, Compressed: compressed, Content: value}
+
+	var envelope *filesystem.Envelope
+	ok := false
+	if envelopes {
+		envelope, ok = filesystem.ParseEnvelope(value)
+	}
+
+	if !ok || (!isArchive && envelope.Archive == "") {
+		if !isArchive {
+			return nil, nil
+		}
+		return &archive, nil
+	}
+
+	if envelope.Encryption != "" {
+		return nil, errors.New(fmt.Sprintf("Envelope of key %s is encrypted, but no decryption key is configured", key))
+	}
+
+	switch envelope.Archive {
+	case "":
+	case "tar", "tar.gz":
+		archive.Dir, archive.Compressed = key, envelope.Archive == "tar.gz"
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported archive format '%s' in envelope of key %s", envelope.Archive, key))
+	}
+
+	content, err := envelope.DecodeContent(maxDecodedSize)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error decoding envelope of key %s: %s", key, err.Error()))
+	}
+
+	archive.Content = content
+	archive.Envelope = envelope
+	return &archive, nil
+}
+
+/*
+Files of an archive key, keyed by their path relative to the prefix
+*/
+type ExpandedArchive struct {
+	Dir   string
+	Files map[string]string
+}
+
+/*
+Extracts the files of an archive key.
+Archives marked by their key's suffix are extracted in a directory named after the key without the suffix while archives marked by an envelope are extracted in a directory named after the key.
+The file metadata of an archive's envelope is carried over to each file it contains.
+Returns nil if the key is not an archive.
+*/
+func ExpandArchive(key string, value string, envelopes bool, maxDecodedSize int64, limits Limits) (*ExpandedArchive, error) {
+	archive, err := getArchive(key, value, envelopes, maxDecodedSize)
+	if err != nil || archive == nil {
+		return nil, err
+	}
+
+	files, err := Extract(archive.Content, archive.Compressed, limits)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error extracting archive of key %s: %s", key, err.Error()))
+	}
+
+	expanded := ExpandedArchive{Dir: archive.Dir, Files: map[string]string{}}
+	for file, content := range files {
+		if archive.Envelope != nil && archive.Envelope.HasMetadata() {
+			content = archive.Envelope.Wrap(content)
+		}
+		expanded.Files[archive.Dir+"/"+file] = content
+	}
+
+	return &expanded, nil
+}
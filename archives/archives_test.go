@@ -0,0 +1,278 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+type archiveEntry struct {
+	Name     string
+	Content  string
+	Typeflag byte
+}
+
+func createArchive(t *testing.T, entries []archiveEntry, compressed bool) string {
+	var buf bytes.Buffer
+	var gzWriter *gzip.Writer
+	tarWriter := tar.NewWriter(&buf)
+	if compressed {
+		gzWriter = gzip.NewWriter(&buf)
+		tarWriter = tar.NewWriter(gzWriter)
+	}
+
+	for _, entry := range entries {
+		header := tar.Header{Name: entry.Name, Mode: 0644, Size: int64(len(entry.Content)), Typeflag: entry.Typeflag}
+		if entry.Typeflag == tar.TypeSymlink {
+			header.Linkname = entry.Content
+			header.Size = 0
+		}
+		if entry.Typeflag == tar.TypeDir {
+			header.Size = 0
+		}
+
+		err := tarWriter.WriteHeader(&header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if header.Size > 0 {
+			_, err = tarWriter.Write([]byte(entry.Content))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	err := tarWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if compressed {
+		err = gzWriter.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buf.String()
+}
+
+var defaultLimits = Limits{MaxEntrySize: DefaultMaxEntrySize, MaxTotalSize: DefaultMaxTotalSize, MaxEntries: DefaultMaxEntries}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name       string
+		entries    []archiveEntry
+		compressed bool
+		limits     Limits
+		expected   map[string]string
+		valid      bool
+	}{
+		{
+			name: "regular files and directories",
+			entries: []archiveEntry{
+				{"dir/", "", tar.TypeDir},
+				{"dir/a.json", "a", tar.TypeReg},
+				{"./b.json", "b", tar.TypeReg},
+			},
+			limits:   defaultLimits,
+			expected: map[string]string{"dir/a.json": "a", "b.json": "b"},
+			valid:    true,
+		},
+		{
+			name:       "compressed",
+			entries:    []archiveEntry{{"a.json", "a", tar.TypeReg}},
+			compressed: true,
+			limits:     defaultLimits,
+			expected:   map[string]string{"a.json": "a"},
+			valid:      true,
+		},
+		{
+			name:     "backslashes are path separators",
+			entries:  []archiveEntry{{"dir\\a.json", "a", tar.TypeReg}},
+			limits:   defaultLimits,
+			expected: map[string]string{"dir/a.json": "a"},
+			valid:    true,
+		},
+		{
+			name:    "symlink",
+			entries: []archiveEntry{{"link", "/etc/passwd", tar.TypeSymlink}},
+			limits:  defaultLimits,
+		},
+		{
+			name:    "absolute path",
+			entries: []archiveEntry{{"/etc/passwd", "a", tar.TypeReg}},
+			limits:  defaultLimits,
+		},
+		{
+			name:    "path outside of the archive's directory",
+			entries: []archiveEntry{{"dir/../../a.json", "a", tar.TypeReg}},
+			limits:  defaultLimits,
+		},
+		{
+			name:    "entry larger than the maximum entry size",
+			entries: []archiveEntry{{"a.json", "0123456789", tar.TypeReg}},
+			limits:  Limits{MaxEntrySize: 9, MaxTotalSize: 100, MaxEntries: 10},
+		},
+		{
+			name:    "files larger than the maximum total size",
+			entries: []archiveEntry{{"a.json", "01234", tar.TypeReg}, {"b.json", "56789", tar.TypeReg}},
+			limits:  Limits{MaxEntrySize: 100, MaxTotalSize: 9, MaxEntries: 10},
+		},
+		{
+			name:    "more entries than the maximum, including directories",
+			entries: []archiveEntry{{"dir/", "", tar.TypeDir}, {"dir/a.json", "a", tar.TypeReg}},
+			limits:  Limits{MaxEntrySize: 100, MaxTotalSize: 100, MaxEntries: 1},
+		},
+		{
+			name:     "entries at the limits",
+			entries:  []archiveEntry{{"a.json", "01234", tar.TypeReg}, {"b.json", "56789", tar.TypeReg}},
+			limits:   Limits{MaxEntrySize: 5, MaxTotalSize: 10, MaxEntries: 2},
+			expected: map[string]string{"a.json": "01234", "b.json": "56789"},
+			valid:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := Extract(createArchive(t, test.entries, test.compressed), test.compressed, test.limits)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("Extract() = %v, expected an error", files)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(files, test.expected) {
+				t.Errorf("Extract() = %v, expected %v", files, test.expected)
+			}
+		})
+	}
+}
+
+func TestExtractCorrupted(t *testing.T) {
+	_, err := Extract("not an archive", true, defaultLimits)
+	if err == nil {
+		t.Errorf("Expected an error for an invalid gzip stream")
+	}
+
+	archive := createArchive(t, []archiveEntry{{"a.json", "0123456789", tar.TypeReg}}, false)
+	_, err = Extract(archive[:515], false, defaultLimits)
+	if err == nil {
+		t.Errorf("Expected an error for a truncated archive")
+	}
+}
+
+func TestExpandArchive(t *testing.T) {
+	archive := createArchive(t, []archiveEntry{{"a.json", "a", tar.TypeReg}}, true)
+	encoded := base64.StdEncoding.EncodeToString([]byte(archive))
+
+	tests := []struct {
+		name      string
+		key       string
+		value     string
+		envelopes bool
+		expected  *ExpandedArchive
+		valid     bool
+	}{
+		{
+			name:  "not an archive",
+			key:   "app.conf",
+			value: "content",
+			valid: true,
+		},
+		{
+			name:     "archive suffix",
+			key:      "dashboards.tar.gz",
+			value:    archive,
+			expected: &ExpandedArchive{Dir: "dashboards", Files: map[string]string{"dashboards/a.json": "a"}},
+			valid:    true,
+		},
+		{
+			name:      "envelope archive",
+			key:       "dashboards",
+			value:     `{"envelope":1,"archive":"tar.gz","encoding":"base64","content":"` + encoded + `"}`,
+			envelopes: true,
+			expected:  &ExpandedArchive{Dir: "dashboards", Files: map[string]string{"dashboards/a.json": "a"}},
+			valid:     true,
+		},
+		{
+			name:      "envelope metadata carried over to the files",
+			key:       "dashboards",
+			value:     `{"envelope":1,"archive":"tar.gz","encoding":"base64","mode":"0600","content":"` + encoded + `"}`,
+			envelopes: true,
+			expected: &ExpandedArchive{Dir: "dashboards", Files: map[string]string{
+				"dashboards/a.json": `{"envelope":1,"content":"YQ==","encoding":"base64","mode":"0600"}`,
+			}},
+			valid: true,
+		},
+		{
+			name:      "unsupported envelope archive format",
+			key:       "dashboards",
+			value:     `{"envelope":1,"archive":"zip","content":""}`,
+			envelopes: true,
+		},
+		{
+			name:      "encrypted envelope",
+			key:       "dashboards",
+			value:     `{"envelope":1,"archive":"tar","encryption":"aes-256-gcm","content":""}`,
+			envelopes: true,
+		},
+		{
+			name:  "invalid archive",
+			key:   "dashboards.tar",
+			value: "not an archive",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expanded, err := ExpandArchive(test.key, test.value, test.envelopes, 1024*1024, defaultLimits)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("ExpandArchive() = %v, expected an error", expanded)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(expanded, test.expected) {
+				t.Errorf("ExpandArchive() = %v, expected %v", expanded, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetArchiveDirectory(t *testing.T) {
+	tests := []struct {
+		key        string
+		dir        string
+		compressed bool
+		isArchive  bool
+	}{
+		{"a.tar", "a", false, true},
+		{"a.tar.gz", "a", true, true},
+		{"dir/a.tgz", "dir/a", true, true},
+		{".tar", "", false, false},
+		{"a.conf", "", false, false},
+	}
+
+	for _, test := range tests {
+		dir, compressed, isArchive := GetArchiveDirectory(test.key)
+		if dir != test.dir || compressed != test.compressed || isArchive != test.isArchive {
+			t.Errorf("GetArchiveDirectory(%q) = %q, %t, %t, expected %q, %t, %t", test.key, dir, compressed, isArchive, test.dir, test.compressed, test.isArchive)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package checksums
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const DefaultManifestKey = "__checksums"
+
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+Returns the manifest of the keys for the publishing side, to be stored in the manifest key once all the keys are written.
+The manifest is a json object mapping every key, relative to the prefix, to the sha256 hash of its value in hex format.
+The manifest key itself is left out of the manifest.
+*/
+func Generate(keys map[string]string, manifestKey string) string {
+	hashes := map[string]string{}
+	for key, val := range keys {
+		if key != manifestKey {
+			hashes[key] = hashValue(val)
+		}
+	}
+
+	manifest, _ := json.Marshal(hashes)
+	return string(manifest)
+}
+
+/*
+Checks the keys against the manifest stored in the manifest key.
+Returns the keys whose value matches the manifest, without the manifest key, and the keys that are held back.
+Keys are held back if their value does not match their hash, if they are not in the manifest or if they are in the manifest, but missing.
+An error is returned if the manifest is missing or invalid, in which case all the keys are held back.
+*/
+func Verify(keys map[string]string, manifestKey string) (map[string]string, map[string]bool, error) {
+	verified := map[string]string{}
+	heldBack := map[string]bool{}
+
+	value, ok := keys[manifestKey]
+	var hashes map[string]string
+	var err error
+	if !ok {
+		err = errors.New(fmt.Sprintf("Manifest key %s is missing", manifestKey))
+	} else if jsonErr := json.Unmarshal([]byte(value), &hashes); jsonErr != nil {
+		err = errors.New(fmt.Sprintf("Manifest key %s is not a valid manifest: %s", manifestKey, jsonErr.Error()))
+	}
+
+	if err != nil {
+		for key, _ := range keys {
+			if key != manifestKey {
+				heldBack[key] = true
+			}
+		}
+		return verified, heldBack, err
+	}
+
+	for key, val := range keys {
+		if key == manifestKey {
+			continue
+		}
+
+		if hash, listed := hashes[key]; listed && hash == hashValue(val) {
+			verified[key] = val
+			continue
+		}
+		heldBack[key] = true
+	}
+
+	for key, _ := range hashes {
+		if _, present := keys[key]; !present && key != manifestKey {
+			heldBack[key] = true
+		}
+	}
+
+	return verified, heldBack, nil
+}
@@ -0,0 +1,106 @@
+package checksums
+
+import (
+	"reflect"
+	"testing"
+)
+
+func withManifest(keys map[string]string, manifest string) map[string]string {
+	result := map[string]string{DefaultManifestKey: manifest}
+	for key, val := range keys {
+		result[key] = val
+	}
+
+	return result
+}
+
+func TestVerify(t *testing.T) {
+	keys := map[string]string{"app.conf": "app", "dir/db.conf": "db"}
+	manifest := Generate(keys, DefaultManifestKey)
+
+	tests := []struct {
+		name     string
+		keys     map[string]string
+		verified map[string]string
+		heldBack map[string]bool
+		valid    bool
+	}{
+		{
+			name:     "all the keys match",
+			keys:     withManifest(keys, manifest),
+			verified: keys,
+			heldBack: map[string]bool{},
+			valid:    true,
+		},
+		{
+			name:     "key not matching its hash",
+			keys:     withManifest(map[string]string{"app.conf": "changed", "dir/db.conf": "db"}, manifest),
+			verified: map[string]string{"dir/db.conf": "db"},
+			heldBack: map[string]bool{"app.conf": true},
+			valid:    true,
+		},
+		{
+			name:     "key not in the manifest",
+			keys:     withManifest(map[string]string{"app.conf": "app", "dir/db.conf": "db", "new.conf": "new"}, manifest),
+			verified: keys,
+			heldBack: map[string]bool{"new.conf": true},
+			valid:    true,
+		},
+		{
+			name:     "key in the manifest, but missing",
+			keys:     withManifest(map[string]string{"app.conf": "app"}, manifest),
+			verified: map[string]string{"app.conf": "app"},
+			heldBack: map[string]bool{"dir/db.conf": true},
+			valid:    true,
+		},
+		{
+			name:     "manifest listing itself",
+			keys:     withManifest(keys, `{"__checksums":"hash"}`),
+			verified: map[string]string{},
+			heldBack: map[string]bool{"app.conf": true, "dir/db.conf": true},
+			valid:    true,
+		},
+		{
+			name:     "missing manifest",
+			keys:     keys,
+			verified: map[string]string{},
+			heldBack: map[string]bool{"app.conf": true, "dir/db.conf": true},
+		},
+		{
+			name:     "invalid manifest",
+			keys:     withManifest(keys, "not json"),
+			verified: map[string]string{},
+			heldBack: map[string]bool{"app.conf": true, "dir/db.conf": true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, heldBack, err := Verify(test.keys, DefaultManifestKey)
+			if test.valid && err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if !test.valid && err == nil {
+				t.Errorf("Expected an error")
+			}
+
+			if !reflect.DeepEqual(verified, test.verified) {
+				t.Errorf("Verify() verified = %v, expected %v", verified, test.verified)
+			}
+
+			if !reflect.DeepEqual(heldBack, test.heldBack) {
+				t.Errorf("Verify() held back = %v, expected %v", heldBack, test.heldBack)
+			}
+		})
+	}
+}
+
+func TestGenerateLeavesOutTheManifestKey(t *testing.T) {
+	manifest := Generate(map[string]string{"app.conf": "app", DefaultManifestKey: "previous"}, DefaultManifestKey)
+
+	expected := `{"app.conf":"` + hashValue("app") + `"}`
+	if manifest != expected {
+		t.Errorf("Generate() = %s, expected %s", manifest, expected)
+	}
+}
@@ -0,0 +1,203 @@
+package chunks
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+/*
+Files split across several keys follow the chunked key format of the etcd sdk (see PutChunkedKey).
+The "<file>/info" key holds the size of the file, the number of chunks and the version of the chunks, which are stored in the "<file>/chunks/v<version>/0", "<file>/chunks/v<version>/1", etc keys.
+*/
+const (
+	InfoKey = "info"
+	//Size of the chunks written by the etcd sdk
+	DefaultChunkSize = 1024 * 1024
+)
+
+var chunkKeyRegex = regexp.MustCompile(`^(.+)/chunks/v([0-9]+)/([0-9]+)$`)
+
+/*
+Returns the key of a chunk of the given version of a file
+*/
+func GetChunkKey(file string, version int64, idx int64) string {
+	return fmt.Sprintf("%s/chunks/v%d/%d", file, version, idx)
+}
+
+func GetInfoKey(file string) string {
+	return fmt.Sprintf("%s/%s", file, InfoKey)
+}
+
+func isInfoKey(key string) bool {
+	return path.Base(key) == InfoKey && path.Dir(key) != "."
+}
+
+/*
+Returns the file a chunk or info key belongs to.
+Returns false if the key is neither.
+*/
+func GetChunkedFile(key string) (string, bool) {
+	if isInfoKey(key) {
+		return path.Dir(key), true
+	}
+
+	if match := chunkKeyRegex.FindStringSubmatch(key); match != nil {
+		return match[1], true
+	}
+
+	return "", false
+}
+
+/*
+Splits the content of a file into chunks of at most the given size, with the given version.
+Returns the chunk keys and the info key with their values. The info should be written last, once all the chunks are.
+*/
+func Split(file string, content []byte, chunkSize int64, version int64) (map[string]string, string, string) {
+	chunkKeys := map[string]string{}
+
+	count := int64(0)
+	for offset := int64(0); offset < int64(len(content)); offset += chunkSize {
+		end := offset + chunkSize
+		if end > int64(len(content)) {
+			end = int64(len(content))
+		}
+
+		chunkKeys[GetChunkKey(file, version, count)] = string(content[offset:end])
+		count++
+	}
+
+	info, _ := json.Marshal(client.ChunkedKeyInfo{
+		Size:    int64(len(content)),
+		Count:   count,
+		Version: version,
+	})
+
+	return chunkKeys, GetInfoKey(file), string(info)
+}
+
+/*
+Parses the value of an info key
+*/
+func ParseInfo(value string) (client.ChunkedKeyInfo, bool) {
+	var info client.ChunkedKeyInfo
+	err := json.Unmarshal([]byte(value), &info)
+	if err != nil || info.Size < 0 || info.Count < 0 {
+		return info, false
+	}
+
+	return info, true
+}
+
+/*
+Reassembled file along with the info and chunk values it was reassembled from
+*/
+type reassembledFile struct {
+	Info    string
+	Chunks  []string
+	Content string
+}
+
+func (f *reassembledFile) matches(file string, keys map[string]string) bool {
+	if keys[GetInfoKey(file)] != f.Info {
+		return false
+	}
+
+	info, _ := ParseInfo(f.Info)
+	for idx, chunk := range f.Chunks {
+		if val, ok := keys[GetChunkKey(file, info.Version, int64(idx))]; !ok || val != chunk {
+			return false
+		}
+	}
+
+	return true
+}
+
+func reassembleFile(file string, keys map[string]string) (reassembledFile, bool) {
+	reassembled := reassembledFile{Info: keys[GetInfoKey(file)], Chunks: []string{}}
+
+	info, ok := ParseInfo(reassembled.Info)
+	if !ok {
+		return reassembled, false
+	}
+
+	var content strings.Builder
+	for idx := int64(0); idx < info.Count; idx++ {
+		chunk, ok := keys[GetChunkKey(file, info.Version, idx)]
+		if !ok {
+			return reassembled, false
+		}
+		content.WriteString(chunk)
+		reassembled.Chunks = append(reassembled.Chunks, chunk)
+	}
+
+	if int64(content.Len()) != info.Size {
+		return reassembled, false
+	}
+
+	reassembled.Content = content.String()
+	return reassembled, true
+}
+
+/*
+Reassembles chunked files, reusing the files reassembled by the previous call whose info and chunks are unchanged
+*/
+type Reassembler struct {
+	files map[string]reassembledFile
+}
+
+/*
+Replaces the chunk and info keys of chunked files in a key space with the reassembled files.
+Only the chunks of the version the info refers to are used, so that chunks of a version being uploaded or of a previous version being deleted are ignored.
+Files whose info is missing or whose chunks do not match it yet (ex: upload in progress) are left out of the key space and returned as pending.
+*/
+func (r *Reassembler) Reassemble(keys map[string]string) (map[string]string, map[string]bool) {
+	result := map[string]string{}
+	chunkedFiles := map[string]bool{}
+
+	for key, val := range keys {
+		if file, ok := GetChunkedFile(key); ok {
+			chunkedFiles[file] = true
+			continue
+		}
+		result[key] = val
+	}
+
+	reassembled := map[string]reassembledFile{}
+	pending := map[string]bool{}
+	for file, _ := range chunkedFiles {
+		if previous, ok := r.files[file]; ok && previous.matches(file, keys) {
+			reassembled[file] = previous
+			result[file] = previous.Content
+			continue
+		}
+
+		current, ok := reassembleFile(file, keys)
+		if !ok {
+			pending[file] = true
+			continue
+		}
+
+		reassembled[file] = current
+		result[file] = current.Content
+	}
+	r.files = reassembled
+
+	return result, pending
+}
+
+/*
+Returns the version following the one of an info value, or 1 if there is no valid info yet
+*/
+func GetNextVersion(infoValue string) int64 {
+	info, ok := ParseInfo(infoValue)
+	if !ok {
+		return 1
+	}
+
+	return info.Version + 1
+}
@@ -0,0 +1,173 @@
+package chunks
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func getChunkedKeys(file string, content string, chunkSize int64, version int64) map[string]string {
+	chunkKeys, infoKey, info := Split(file, []byte(content), chunkSize, version)
+	chunkKeys[infoKey] = info
+	return chunkKeys
+}
+
+func mergeKeys(keySpaces ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, keys := range keySpaces {
+		for key, val := range keys {
+			merged[key] = val
+		}
+	}
+
+	return merged
+}
+
+func TestReassemble(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	chunked := getChunkedKeys("dir/big.conf", content, 30, 2)
+
+	missingChunk := mergeKeys(chunked)
+	delete(missingChunk, GetChunkKey("dir/big.conf", 2, 2))
+
+	alteredChunk := mergeKeys(chunked)
+	alteredChunk[GetChunkKey("dir/big.conf", 2, 1)] = strings.Repeat("x", 29)
+
+	otherVersions := mergeKeys(chunked)
+	otherVersions[GetChunkKey("dir/big.conf", 1, 0)] = "previous"
+	otherVersions[GetChunkKey("dir/big.conf", 3, 0)] = "next"
+
+	missingInfo := mergeKeys(chunked)
+	delete(missingInfo, GetInfoKey("dir/big.conf"))
+
+	invalidInfo := mergeKeys(chunked)
+	invalidInfo[GetInfoKey("dir/big.conf")] = "not json"
+
+	tests := []struct {
+		name    string
+		keys    map[string]string
+		values  map[string]string
+		pending map[string]bool
+	}{
+		{
+			name:    "complete file",
+			keys:    mergeKeys(chunked, map[string]string{"other.conf": "other"}),
+			values:  map[string]string{"dir/big.conf": content, "other.conf": "other"},
+			pending: map[string]bool{},
+		},
+		{
+			name:    "info as written by the etcd sdk",
+			keys:    map[string]string{"big.conf/info": `{"Size":6,"Count":2,"Version":1}`, "big.conf/chunks/v1/0": "abc", "big.conf/chunks/v1/1": "def"},
+			values:  map[string]string{"big.conf": "abcdef"},
+			pending: map[string]bool{},
+		},
+		{
+			name:    "empty file",
+			keys:    getChunkedKeys("empty.conf", "", 30, 1),
+			values:  map[string]string{"empty.conf": ""},
+			pending: map[string]bool{},
+		},
+		{
+			name:    "chunks of other versions",
+			keys:    otherVersions,
+			values:  map[string]string{"dir/big.conf": content},
+			pending: map[string]bool{},
+		},
+		{
+			name:    "missing chunk",
+			keys:    missingChunk,
+			values:  map[string]string{},
+			pending: map[string]bool{"dir/big.conf": true},
+		},
+		{
+			name:    "chunks not matching the size of the info",
+			keys:    alteredChunk,
+			values:  map[string]string{},
+			pending: map[string]bool{"dir/big.conf": true},
+		},
+		{
+			name:    "missing info",
+			keys:    missingInfo,
+			values:  map[string]string{},
+			pending: map[string]bool{"dir/big.conf": true},
+		},
+		{
+			name:    "invalid info",
+			keys:    invalidInfo,
+			values:  map[string]string{},
+			pending: map[string]bool{"dir/big.conf": true},
+		},
+		{
+			name:    "top-level keys with the chunked key naming are regular files",
+			keys:    map[string]string{"info": "a", "chunks/v1/0": "b"},
+			values:  map[string]string{"info": "a", "chunks/v1/0": "b"},
+			pending: map[string]bool{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var reassembler Reassembler
+			values, pending := reassembler.Reassemble(test.keys)
+
+			if !reflect.DeepEqual(values, test.values) {
+				t.Errorf("Reassemble() values = %v, expected %v", values, test.values)
+			}
+
+			if !reflect.DeepEqual(pending, test.pending) {
+				t.Errorf("Reassemble() pending = %v, expected %v", pending, test.pending)
+			}
+		})
+	}
+}
+
+func TestReassembleReusesUnchangedFiles(t *testing.T) {
+	var reassembler Reassembler
+
+	keys := getChunkedKeys("big.conf", "first version", 4, 1)
+	values, _ := reassembler.Reassemble(keys)
+	if values["big.conf"] != "first version" {
+		t.Fatalf("Unexpected content %q", values["big.conf"])
+	}
+
+	values, _ = reassembler.Reassemble(keys)
+	if values["big.conf"] != "first version" {
+		t.Errorf("Unexpected content %q for an unchanged file", values["big.conf"])
+	}
+
+	//A new version whose info is uploaded before its chunks is pending rather than reusing the previous content
+	_, infoKey, info := Split("big.conf", []byte("second version"), 4, 2)
+	keys[infoKey] = info
+	values, pending := reassembler.Reassemble(keys)
+	if _, ok := values["big.conf"]; ok || !pending["big.conf"] {
+		t.Errorf("Expected the file to be pending, got values %v and pending %v", values, pending)
+	}
+
+	keys = getChunkedKeys("big.conf", "second version", 4, 2)
+	values, _ = reassembler.Reassemble(keys)
+	if values["big.conf"] != "second version" {
+		t.Errorf("Unexpected content %q once all the chunks are uploaded", values["big.conf"])
+	}
+}
+
+func TestGetChunkedFile(t *testing.T) {
+	tests := []struct {
+		key     string
+		file    string
+		chunked bool
+	}{
+		{"dir/big.conf/info", "dir/big.conf", true},
+		{"dir/big.conf/chunks/v3/12", "dir/big.conf", true},
+		{"info", "", false},
+		{"dir/big.conf/chunks/3/12", "", false},
+		{"dir/big.conf/chunks/v3/", "", false},
+		{"dir/app.conf", "", false},
+	}
+
+	for _, test := range tests {
+		file, chunked := GetChunkedFile(test.key)
+		if file != test.file || chunked != test.chunked {
+			t.Errorf("GetChunkedFile(%q) = %q, %t, expected %q, %t", test.key, file, chunked, test.file, test.chunked)
+		}
+	}
+}
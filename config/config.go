@@ -1,17 +1,27 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	yaml "gopkg.in/yaml.v2"
 	"io/ioutil"
+	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/aggregates"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/archives"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/checksums"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/filesystem"
 	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/logger"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/signatures"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/validation"
 )
 
 type EtcdPasswordAuth struct {
@@ -31,6 +41,7 @@ type ConfigEtcdAuth struct {
 
 type ConfigEtcd struct {
 	Prefix            string
+	Prefixes          []string
 	Endpoints         []string
 	ConnectionTimeout time.Duration `yaml:"connection_timeout"`
 	RequestTimeout    time.Duration `yaml:"request_timeout"`
@@ -39,11 +50,93 @@ type ConfigEtcd struct {
 	Auth              ConfigEtcdAuth
 }
 
+type ConfigFilesystemSwap struct {
+	Enabled      bool
+	KeptVersions uint64 `yaml:"kept_versions"`
+}
+
+type ConfigPermissionRule struct {
+	Pattern string
+	Mode    string
+	User    string
+	Group   string
+	Uid     int `yaml:"-"`
+	Gid     int `yaml:"-"`
+}
+
+type ConfigOverrides struct {
+	Enabled  bool
+	Hostname string
+	Groups   []string
+}
+
+type ConfigRewriteRule struct {
+	Type       string
+	Match      string
+	MatchRegex *regexp.Regexp `yaml:"-"`
+	Replace    string
+	Extension  string
+	Separator  string
+}
+
+type ConfigAggregate struct {
+	File      string
+	Prefix    string
+	Format    string
+	Separator *string
+}
+
+type ConfigSignatures struct {
+	PublicKeys  []string            `yaml:"public_keys"`
+	ManifestKey string              `yaml:"manifest_key"`
+	StatePath   string              `yaml:"state_path"`
+	TrustedKeys []ed25519.PublicKey `yaml:"-"`
+}
+
+type ConfigChecksums struct {
+	Enabled     bool
+	ManifestKey string `yaml:"manifest_key"`
+}
+
+type ConfigValidation struct {
+	Enabled bool
+	Policy  string
+}
+
+type ConfigArchiveLimits struct {
+	MaxEntrySize int64 `yaml:"max_entry_size"`
+	MaxTotalSize int64 `yaml:"max_total_size"`
+	MaxEntries   int64 `yaml:"max_entries"`
+}
+
 type ConfigFilesystem struct {
 	Path                  string
 	SlashPath             string `yaml:"-"`
 	FilesPermission       string `yaml:"files_permission"`
 	DirectoriesPermission string `yaml:"directories_permission"`
+	Swap                  ConfigFilesystemSwap
+	ManagedFilesOnly      bool `yaml:"managed_files_only"`
+	Exclude               []string
+	InvalidKeysPolicy     string                 `yaml:"invalid_keys_policy"`
+	PermissionRules       []ConfigPermissionRule `yaml:"permission_rules"`
+	Envelopes             bool
+	EncodingSuffixes      bool  `yaml:"encoding_suffixes"`
+	MaxDecodedSize        int64 `yaml:"max_decoded_size"`
+	ChunkedFiles          bool  `yaml:"chunked_files"`
+	Archives              bool
+	ArchiveLimits         ConfigArchiveLimits `yaml:"archive_limits"`
+	Templates             bool
+	TemplatesEnv          []string `yaml:"templates_env"`
+	Overrides             ConfigOverrides
+	KeyFilter             string              `yaml:"key_filter"`
+	KeyFilterRegex        *regexp.Regexp      `yaml:"-"`
+	RewriteRules          []ConfigRewriteRule `yaml:"rewrite_rules"`
+	Aggregates            []ConfigAggregate
+	DecryptionKeyFile     string `yaml:"decryption_key_file"`
+	DecryptionKey         []byte `yaml:"-"`
+	Signatures            ConfigSignatures
+	Checksums             ConfigChecksums
+	Validation            ConfigValidation
 }
 
 type ConfigGrpcAuth struct {
@@ -61,13 +154,30 @@ type ConfigGrpcNotifications struct {
 	Auth              ConfigGrpcAuth
 }
 
+/*
+Synchronization of etcd prefixes with a directory, running independently from the other jobs of the process
+*/
+type ConfigJob struct {
+	Name                       string
+	Prefix                     string
+	Prefixes                   []string
+	Filesystem                 ConfigFilesystem
+	GrpcNotifications          []ConfigGrpcNotifications `yaml:"grpc_notifications"`
+	NotificationCommand        []string                  `yaml:"notification_command"`
+	NotificationCommandRetries uint64                    `yaml:"notification_command_retries"`
+	JournalPath                string                    `yaml:"journal_path"`
+}
+
 type Config struct {
 	Filesystem                 ConfigFilesystem
 	EtcdClient                 ConfigEtcd                `yaml:"etcd_client"`
 	GrpcNotifications          []ConfigGrpcNotifications `yaml:"grpc_notifications"`
 	NotificationCommand        []string                  `yaml:"notification_command"`
 	NotificationCommandRetries uint64                    `yaml:"notification_command_retries"`
-	LogLevel                   string                    `yaml:"log_level"`
+	JournalPath                string                    `yaml:"journal_path"`
+	Jobs                       []ConfigJob
+	JobFailurePolicy           string `yaml:"job_failure_policy"`
+	LogLevel                   string `yaml:"log_level"`
 }
 
 func (c *Config) GetLogLevel() int64 {
@@ -84,11 +194,126 @@ func (c *Config) GetLogLevel() int64 {
 	}
 }
 
-func checkConfigIntegrity(c Config) error {
-	if c.Filesystem.Path == "" {
+func checkJobIntegrity(job ConfigJob) error {
+	if job.Filesystem.Path == "" {
 		return errors.New("Configuration error: Filesystem path cannot be empty")
 	}
 
+	if job.Filesystem.InvalidKeysPolicy != "fail" && job.Filesystem.InvalidKeysPolicy != "skip" {
+		return errors.New("Configuration error: Filesystem invalid keys policy must be either 'fail' or 'skip'")
+	}
+
+	if job.Filesystem.MaxDecodedSize < 0 {
+		return errors.New("Configuration error: Filesystem max decoded size cannot be negative")
+	}
+
+	if job.Filesystem.ArchiveLimits.MaxEntrySize < 0 || job.Filesystem.ArchiveLimits.MaxTotalSize < 0 || job.Filesystem.ArchiveLimits.MaxEntries < 0 {
+		return errors.New("Configuration error: Filesystem archive limits cannot be negative")
+	}
+
+	if job.Filesystem.Validation.Policy != validation.PolicyRejectDiff && job.Filesystem.Validation.Policy != validation.PolicyRejectFile {
+		return errors.New(fmt.Sprintf("Configuration error: Filesystem validation policy must be either '%s' or '%s'", validation.PolicyRejectDiff, validation.PolicyRejectFile))
+	}
+
+	for _, rule := range job.Filesystem.PermissionRules {
+		_, matchErr := path.Match(rule.Pattern, "")
+		if rule.Pattern == "" || matchErr != nil {
+			return errors.New(fmt.Sprintf("Configuration error: Permission rule pattern '%s' is not a valid glob pattern", rule.Pattern))
+		}
+
+		if rule.Mode != "" {
+			parsedPermission, err := strconv.ParseInt(rule.Mode, 8, 32)
+			if err != nil || parsedPermission < 0 || parsedPermission > 511 {
+				return errors.New(fmt.Sprintf("Configuration error: Permission rule mode for pattern '%s' must constitute a valid unix value for file permissions", rule.Pattern))
+			}
+		}
+	}
+
+	for _, pattern := range job.Filesystem.Exclude {
+		_, matchErr := path.Match(pattern, "")
+		if matchErr != nil {
+			return errors.New(fmt.Sprintf("Configuration error: Filesystem exclude pattern %s is not a valid glob pattern", pattern))
+		}
+	}
+
+	for _, rule := range job.Filesystem.RewriteRules {
+		if rule.Type != filesystem.RewriteRegex && rule.Type != filesystem.RewriteStripExtension && rule.Type != filesystem.RewriteFlatten {
+			return errors.New(fmt.Sprintf("Configuration error: Rewrite rule type '%s' must be either 'regex', 'strip_extension' or 'flatten'", rule.Type))
+		}
+
+		if rule.Type == filesystem.RewriteRegex && rule.Match == "" {
+			return errors.New("Configuration error: Rewrite rules of the 'regex' type must have a match pattern")
+		}
+	}
+
+	for _, agg := range job.Filesystem.Aggregates {
+		if agg.File == "" || agg.Prefix == "" {
+			return errors.New("Configuration error: Aggregates must have a file and a prefix")
+		}
+
+		if strings.HasPrefix(agg.File, agg.Prefix) {
+			return errors.New(fmt.Sprintf("Configuration error: Aggregate file %s cannot be under its own prefix", agg.File))
+		}
+
+		if agg.Format != aggregates.FormatConcat && agg.Format != aggregates.FormatEnv && agg.Format != aggregates.FormatJson && agg.Format != aggregates.FormatYaml {
+			return errors.New(fmt.Sprintf("Configuration error: Format of aggregate file %s must be either 'concat', 'env', 'json' or 'yaml'", agg.File))
+		}
+	}
+
+	if job.Filesystem.Overrides.Enabled {
+		if job.Filesystem.Overrides.Hostname == "" || strings.Contains(job.Filesystem.Overrides.Hostname, "/") {
+			return errors.New("Configuration error: Overrides hostname cannot be empty or contain a slash")
+		}
+
+		for _, group := range job.Filesystem.Overrides.Groups {
+			if group == "" || strings.Contains(group, "/") {
+				return errors.New(fmt.Sprintf("Configuration error: Overrides group '%s' cannot be empty or contain a slash", group))
+			}
+		}
+	}
+
+	if job.JournalPath != "" {
+		slashJournalPath := filepath.ToSlash(job.JournalPath)
+		if job.JournalPath == job.Filesystem.Path || (strings.HasPrefix(slashJournalPath, job.Filesystem.SlashPath) && !filesystem.IsExcluded(strings.TrimPrefix(slashJournalPath, job.Filesystem.SlashPath), job.Filesystem.Exclude)) {
+			return errors.New("Configuration error: Journal path cannot be inside the filesystem path unless it is excluded")
+		}
+	}
+
+	if len(job.Filesystem.Signatures.PublicKeys) > 0 && job.Filesystem.Signatures.StatePath == "" {
+		return errors.New("Configuration error: Signatures state path cannot be empty when public keys are set")
+	}
+
+	if job.Filesystem.Signatures.StatePath != "" {
+		slashStatePath := filepath.ToSlash(job.Filesystem.Signatures.StatePath)
+		if job.Filesystem.Signatures.StatePath == job.Filesystem.Path || (strings.HasPrefix(slashStatePath, job.Filesystem.SlashPath) && !filesystem.IsExcluded(strings.TrimPrefix(slashStatePath, job.Filesystem.SlashPath), job.Filesystem.Exclude)) {
+			return errors.New("Configuration error: Signatures state path cannot be inside the filesystem path unless it is excluded")
+		}
+	}
+
+	if len(job.Prefixes) == 0 {
+		return errors.New("Configuration error: Etcd key prefix cannot be empty")
+	}
+
+	for _, prefix := range job.Prefixes {
+		if prefix == "" {
+			return errors.New("Configuration error: Etcd key prefixes cannot be empty")
+		}
+	}
+
+	parsedPermission, err := strconv.ParseInt(job.Filesystem.FilesPermission, 8, 32)
+	if err != nil || parsedPermission < 0 || parsedPermission > 511 {
+		return errors.New("Configuration error: Files permission must constitute a valid unix value for file permissions")
+	}
+
+	parsedPermission, err = strconv.ParseInt(job.Filesystem.DirectoriesPermission, 8, 32)
+	if err != nil || parsedPermission < 0 || parsedPermission > 511 {
+		return errors.New("Configuration error: Directories permission must constitute a valid unix value for file permissions")
+	}
+
+	return nil
+}
+
+func checkConfigIntegrity(c Config) error {
 	if len(c.EtcdClient.Endpoints) == 0 {
 		return errors.New("Configuration error: Etcd endpoints cannot be empty")
 	}
@@ -104,18 +329,38 @@ func checkConfigIntegrity(c Config) error {
 		return errors.New("Configuration error: Either user certificate AND key path should not be empty XOR user name AND password should not be empty")
 	}
 
-	if c.EtcdClient.Prefix == "" {
-		return errors.New("Configuration error: Etcd key prefix cannot be empty")
+	if c.JobFailurePolicy != "exit" && c.JobFailurePolicy != "continue" {
+		return errors.New("Configuration error: Job failure policy must be either 'exit' or 'continue'")
 	}
 
-	parsedPermission, err := strconv.ParseInt(c.Filesystem.FilesPermission, 8, 32)
-	if err != nil || parsedPermission < 0 || parsedPermission > 511 {
-		return errors.New("Configuration error: Files permission must constitute a valid unix value for file permissions")
-	}
+	names := map[string]bool{}
+	for idx, job := range c.Jobs {
+		err := checkJobIntegrity(job)
+		if err != nil {
+			if len(c.Jobs) > 1 {
+				return errors.New(fmt.Sprintf("%s (job '%s')", err.Error(), job.Name))
+			}
+			return err
+		}
 
-	parsedPermission, err = strconv.ParseInt(c.Filesystem.DirectoriesPermission, 8, 32)
-	if err != nil || parsedPermission < 0 || parsedPermission > 511 {
-		return errors.New("Configuration error: Directories permission must constitute a valid unix value for file permissions")
+		if names[job.Name] {
+			return errors.New(fmt.Sprintf("Configuration error: Job name '%s' is not unique", job.Name))
+		}
+		names[job.Name] = true
+
+		for _, other := range c.Jobs[:idx] {
+			if strings.HasPrefix(job.Filesystem.SlashPath, other.Filesystem.SlashPath) || strings.HasPrefix(other.Filesystem.SlashPath, job.Filesystem.SlashPath) {
+				return errors.New(fmt.Sprintf("Configuration error: Filesystem paths of jobs '%s' and '%s' cannot overlap", other.Name, job.Name))
+			}
+
+			if job.JournalPath != "" && job.JournalPath == other.JournalPath {
+				return errors.New(fmt.Sprintf("Configuration error: Jobs '%s' and '%s' cannot share the same journal path", other.Name, job.Name))
+			}
+
+			if job.Filesystem.Signatures.StatePath != "" && job.Filesystem.Signatures.StatePath == other.Filesystem.Signatures.StatePath {
+				return errors.New(fmt.Sprintf("Configuration error: Jobs '%s' and '%s' cannot share the same signatures state path", other.Name, job.Name))
+			}
+		}
 	}
 
 	return nil
@@ -137,21 +382,194 @@ func getPasswordAuth(path string) (EtcdPasswordAuth, error) {
 	return a, nil
 }
 
-func setGrpcEndpointsRegex(c *Config) error {
-	for idx, notif := range c.GrpcNotifications {
+func setGrpcEndpointsRegex(job *ConfigJob) error {
+	for idx, notif := range job.GrpcNotifications {
 		if notif.Filter != "" {
 			exp, expErr := regexp.Compile(notif.Filter)
 			if expErr != nil {
 				return expErr
 			}
 			notif.FilterRegex = exp
-			c.GrpcNotifications[idx] = notif
+			job.GrpcNotifications[idx] = notif
+		}
+	}
+
+	return nil
+}
+
+func setFilesystemRegexes(job *ConfigJob) error {
+	if job.Filesystem.KeyFilter != "" {
+		exp, expErr := regexp.Compile(job.Filesystem.KeyFilter)
+		if expErr != nil {
+			return errors.New(fmt.Sprintf("Configuration error: Filesystem key filter is not a valid regex: %s", expErr.Error()))
+		}
+		job.Filesystem.KeyFilterRegex = exp
+	}
+
+	for idx, rule := range job.Filesystem.RewriteRules {
+		if rule.Match != "" {
+			exp, expErr := regexp.Compile(rule.Match)
+			if expErr != nil {
+				return errors.New(fmt.Sprintf("Configuration error: Rewrite rule match pattern '%s' is not a valid regex: %s", rule.Match, expErr.Error()))
+			}
+			rule.MatchRegex = exp
+			job.Filesystem.RewriteRules[idx] = rule
 		}
 	}
 
 	return nil
 }
 
+func setPermissionRulesIds(job *ConfigJob) error {
+	for idx, rule := range job.Filesystem.PermissionRules {
+		uid, uidErr := filesystem.ResolveUid(rule.User)
+		if uidErr != nil {
+			return errors.New(fmt.Sprintf("Error resolving user of permission rule for pattern '%s': %s", rule.Pattern, uidErr.Error()))
+		}
+
+		gid, gidErr := filesystem.ResolveGid(rule.Group)
+		if gidErr != nil {
+			return errors.New(fmt.Sprintf("Error resolving group of permission rule for pattern '%s': %s", rule.Pattern, gidErr.Error()))
+		}
+
+		rule.Uid = uid
+		rule.Gid = gid
+		job.Filesystem.PermissionRules[idx] = rule
+	}
+
+	return nil
+}
+
+func setJobDefaults(job *ConfigJob) error {
+	if job.Filesystem.FilesPermission == "" {
+		job.Filesystem.FilesPermission = "0660"
+	}
+
+	if job.Filesystem.DirectoriesPermission == "" {
+		job.Filesystem.DirectoriesPermission = "0770"
+	}
+
+	if job.Filesystem.InvalidKeysPolicy == "" {
+		job.Filesystem.InvalidKeysPolicy = "fail"
+	}
+
+	if job.Filesystem.MaxDecodedSize == 0 {
+		job.Filesystem.MaxDecodedSize = filesystem.DefaultMaxDecodedSize
+	}
+
+	if job.Filesystem.ArchiveLimits.MaxEntrySize == 0 {
+		job.Filesystem.ArchiveLimits.MaxEntrySize = archives.DefaultMaxEntrySize
+	}
+
+	if job.Filesystem.ArchiveLimits.MaxTotalSize == 0 {
+		job.Filesystem.ArchiveLimits.MaxTotalSize = archives.DefaultMaxTotalSize
+	}
+
+	if job.Filesystem.ArchiveLimits.MaxEntries == 0 {
+		job.Filesystem.ArchiveLimits.MaxEntries = archives.DefaultMaxEntries
+	}
+
+	if job.Filesystem.Validation.Policy == "" {
+		job.Filesystem.Validation.Policy = validation.PolicyRejectDiff
+	}
+
+	if job.Filesystem.Swap.KeptVersions == 0 {
+		job.Filesystem.Swap.KeptVersions = 2
+	}
+
+	for idx, agg := range job.Filesystem.Aggregates {
+		if agg.Separator == nil {
+			separator := "\n"
+			agg.Separator = &separator
+			job.Filesystem.Aggregates[idx] = agg
+		}
+	}
+
+	if len(job.Prefixes) == 0 && job.Prefix != "" {
+		job.Prefixes = []string{job.Prefix}
+	} else if len(job.Prefixes) > 0 && job.Prefix != "" {
+		return errors.New("Configuration error: Etcd key prefix and prefixes cannot both be set")
+	}
+
+	if job.Filesystem.Overrides.Enabled && job.Filesystem.Overrides.Hostname == "" {
+		hostname, hostErr := os.Hostname()
+		if hostErr != nil {
+			return errors.New(fmt.Sprintf("Error getting the hostname for overrides: %s", hostErr.Error()))
+		}
+		job.Filesystem.Overrides.Hostname = hostname
+	}
+
+	if job.Filesystem.Path != "" {
+		absPath, absPathErr := filepath.Abs(job.Filesystem.Path)
+		if absPathErr != nil {
+			return errors.New(fmt.Sprintf("Error conversion filesystem path to absolute path: %s", absPathErr.Error()))
+		}
+
+		job.Filesystem.Path = absPath
+		job.Filesystem.SlashPath = filepath.ToSlash(absPath)
+		if job.Filesystem.SlashPath[len(job.Filesystem.SlashPath)-1:] != "/" {
+			job.Filesystem.SlashPath = job.Filesystem.SlashPath + "/"
+		}
+	}
+
+	if job.JournalPath != "" {
+		absJournalPath, absJournalPathErr := filepath.Abs(job.JournalPath)
+		if absJournalPathErr != nil {
+			return errors.New(fmt.Sprintf("Error conversion journal path to absolute path: %s", absJournalPathErr.Error()))
+		}
+		job.JournalPath = absJournalPath
+	}
+
+	if job.Filesystem.Signatures.StatePath != "" {
+		absStatePath, absStatePathErr := filepath.Abs(job.Filesystem.Signatures.StatePath)
+		if absStatePathErr != nil {
+			return errors.New(fmt.Sprintf("Error conversion signatures state path to absolute path: %s", absStatePathErr.Error()))
+		}
+		job.Filesystem.Signatures.StatePath = absStatePath
+	}
+
+	if job.Filesystem.Signatures.ManifestKey == "" {
+		job.Filesystem.Signatures.ManifestKey = signatures.DefaultManifestKey
+	}
+
+	if job.Filesystem.Checksums.ManifestKey == "" {
+		job.Filesystem.Checksums.ManifestKey = checksums.DefaultManifestKey
+	}
+
+	for _, encodedKey := range job.Filesystem.Signatures.PublicKeys {
+		publicKey, keyErr := base64.StdEncoding.DecodeString(encodedKey)
+		if keyErr != nil || len(publicKey) != ed25519.PublicKeySize {
+			return errors.New(fmt.Sprintf("Configuration error: Signatures public key '%s' is not a base64 encoded ed25519 public key", encodedKey))
+		}
+		job.Filesystem.Signatures.TrustedKeys = append(job.Filesystem.Signatures.TrustedKeys, ed25519.PublicKey(publicKey))
+	}
+
+	if job.Filesystem.DecryptionKeyFile != "" {
+		key, keyErr := filesystem.LoadDecryptionKey(job.Filesystem.DecryptionKeyFile)
+		if keyErr != nil {
+			return keyErr
+		}
+		job.Filesystem.DecryptionKey = key
+	}
+
+	idsErr := setPermissionRulesIds(job)
+	if idsErr != nil {
+		return idsErr
+	}
+
+	expErr := setGrpcEndpointsRegex(job)
+	if expErr != nil {
+		return expErr
+	}
+
+	expErr = setFilesystemRegexes(job)
+	if expErr != nil {
+		return expErr
+	}
+
+	return nil
+}
+
 func GetConfig(confFilePath string) (Config, error) {
 	var c Config
 
@@ -174,28 +592,37 @@ func GetConfig(confFilePath string) (Config, error) {
 		c.EtcdClient.Auth.Password = pAuth.Password
 	}
 
-	if c.Filesystem.FilesPermission == "" {
-		c.Filesystem.FilesPermission = "0660"
+	if len(c.Jobs) == 0 {
+		c.Jobs = []ConfigJob{{
+			Name:                       "default",
+			Prefix:                     c.EtcdClient.Prefix,
+			Prefixes:                   c.EtcdClient.Prefixes,
+			Filesystem:                 c.Filesystem,
+			GrpcNotifications:          c.GrpcNotifications,
+			NotificationCommand:        c.NotificationCommand,
+			NotificationCommandRetries: c.NotificationCommandRetries,
+			JournalPath:                c.JournalPath,
+		}}
+	} else if c.Filesystem.Path != "" || c.EtcdClient.Prefix != "" || len(c.EtcdClient.Prefixes) > 0 {
+		return Config{}, errors.New("Configuration error: The filesystem and prefixes cannot be set at the top level when jobs are set")
+	} else if len(c.NotificationCommand) > 0 || c.NotificationCommandRetries > 0 || len(c.GrpcNotifications) > 0 || c.JournalPath != "" {
+		return Config{}, errors.New("Configuration error: The notification command, grpc notifications and journal path cannot be set at the top level when jobs are set. They should be set in each job instead")
 	}
 
-	if c.Filesystem.DirectoriesPermission == "" {
-		c.Filesystem.DirectoriesPermission = "0770"
+	if c.JobFailurePolicy == "" {
+		c.JobFailurePolicy = "exit"
 	}
 
-	absPath, absPathErr := filepath.Abs(c.Filesystem.Path)
-	if absPathErr != nil {
-		return Config{}, errors.New(fmt.Sprintf("Error conversion filesystem path to absolute path: %s", absPathErr.Error()))
-	}
-
-	c.Filesystem.Path = absPath
-	c.Filesystem.SlashPath = filepath.ToSlash(absPath)
-	if c.Filesystem.SlashPath[len(c.Filesystem.SlashPath)-1:] != "/" {
-		c.Filesystem.SlashPath = c.Filesystem.SlashPath + "/"
-	}
+	for idx, job := range c.Jobs {
+		if job.Name == "" {
+			job.Name = fmt.Sprintf("job-%d", idx+1)
+		}
 
-	expErr := setGrpcEndpointsRegex(&c)
-	if expErr != nil {
-		return c, expErr
+		jobErr := setJobDefaults(&job)
+		if jobErr != nil {
+			return c, jobErr
+		}
+		c.Jobs[idx] = job
 	}
 
 	err = checkConfigIntegrity(c)
@@ -0,0 +1,205 @@
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+/*
+Attributes to give to a file written in the directory.
+A negative uid or gid leaves the corresponding ownership unchanged and a zero modification time leaves it to the time of the write.
+*/
+type FileAttributes struct {
+	Mode    os.FileMode
+	Uid     int
+	Gid     int
+	ModTime time.Time
+}
+
+func resolveId(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+
+	id, err := strconv.Atoi(name)
+	if err == nil {
+		return id, nil
+	}
+
+	idStr, lookupErr := lookup(name)
+	if lookupErr != nil {
+		return -1, lookupErr
+	}
+
+	return strconv.Atoi(idStr)
+}
+
+/*
+Returns the uid of a user given either its name or its uid. An empty name returns -1.
+*/
+func ResolveUid(name string) (int, error) {
+	return resolveId(name, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+}
+
+/*
+Returns the gid of a group given either its name or its gid. An empty name returns -1.
+*/
+func ResolveGid(name string) (int, error) {
+	return resolveId(name, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+}
+
+/*
+Returns the attributes with the fields that are set in the override replacing the base ones
+*/
+func mergeAttributes(base FileAttributes, override FileAttributes) FileAttributes {
+	if override.Mode != 0 {
+		base.Mode = override.Mode
+	}
+
+	if override.Uid >= 0 {
+		base.Uid = override.Uid
+	}
+
+	if override.Gid >= 0 {
+		base.Gid = override.Gid
+	}
+
+	if !override.ModTime.IsZero() {
+		base.ModTime = override.ModTime
+	}
+
+	return base
+}
+
+/*
+Rule overriding the attributes of the files matching its glob pattern.
+A zero mode keeps the default files permission and a negative uid or gid leaves the corresponding ownership unchanged.
+*/
+type PermissionRule struct {
+	Pattern string
+	Mode    os.FileMode
+	Uid     int
+	Gid     int
+}
+
+/*
+Returns the attributes of a file given its relative path with forward slashes.
+The first rule whose pattern matches the path is applied and files that match no rule get the default permission with unchanged ownership.
+*/
+func GetFileAttributes(rel string, rules []PermissionRule, defaultPermission os.FileMode) FileAttributes {
+	for _, rule := range rules {
+		if !MatchesPattern(rel, rule.Pattern) {
+			continue
+		}
+
+		attrs := FileAttributes{Mode: rule.Mode, Uid: rule.Uid, Gid: rule.Gid}
+		if attrs.Mode == 0 {
+			attrs.Mode = defaultPermission
+		}
+		return attrs
+	}
+
+	return FileAttributes{Mode: defaultPermission, Uid: -1, Gid: -1}
+}
+
+func applyAttributes(f *os.File, attrs FileAttributes) error {
+	err := f.Chmod(attrs.Mode)
+	if err != nil {
+		return err
+	}
+
+	if attrs.Uid >= 0 || attrs.Gid >= 0 {
+		err = f.Chown(attrs.Uid, attrs.Gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !attrs.ModTime.IsZero() {
+		return os.Chtimes(f.Name(), attrs.ModTime, attrs.ModTime)
+	}
+
+	return nil
+}
+
+/*
+Returns whether the existing file's mode or ownership differ from the expected attributes
+*/
+func hasDrifted(info os.FileInfo, attrs FileAttributes) bool {
+	if info.Mode().Perm() != attrs.Mode.Perm() {
+		return true
+	}
+
+	if !attrs.ModTime.IsZero() && !info.ModTime().Equal(attrs.ModTime) {
+		return true
+	}
+
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return false
+	}
+
+	return (attrs.Uid >= 0 && attrs.Uid != uid) || (attrs.Gid >= 0 && attrs.Gid != gid)
+}
+
+/*
+Corrects the mode, ownership and modification time of existing files in the directory that have drifted from the expected attributes.
+Files are keyed by their relative path with forward slashes and the files that were corrected are returned.
+*/
+func EnforceFileAttributes(path string, expected map[string]FileAttributes) ([]string, error) {
+	corrected := []string{}
+
+	for file, attrs := range expected {
+		fPath := filepath.Join(path, filepath.FromSlash(file))
+		info, err := os.Lstat(fPath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return corrected, err
+		}
+
+		if !info.Mode().IsRegular() || !hasDrifted(info, attrs) {
+			continue
+		}
+
+		err = os.Chmod(fPath, attrs.Mode)
+		if err != nil {
+			return corrected, err
+		}
+
+		if attrs.Uid >= 0 || attrs.Gid >= 0 {
+			err = os.Lchown(fPath, attrs.Uid, attrs.Gid)
+			if err != nil {
+				return corrected, err
+			}
+		}
+
+		if !attrs.ModTime.IsZero() {
+			err = os.Chtimes(fPath, attrs.ModTime, attrs.ModTime)
+			if err != nil {
+				return corrected, err
+			}
+		}
+
+		corrected = append(corrected, file)
+	}
+
+	return corrected, nil
+}
@@ -0,0 +1,159 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestGetFileAttributes(t *testing.T) {
+	rules := []PermissionRule{
+		{Pattern: "secrets/*", Mode: 0400, Uid: 1000, Gid: 1000},
+		{Pattern: "*.sh", Mode: 0, Uid: -1, Gid: 50},
+		{Pattern: "secrets/*", Mode: 0444, Uid: -1, Gid: -1},
+	}
+
+	tests := []struct {
+		name     string
+		rel      string
+		expected FileAttributes
+	}{
+		{
+			name:     "first matching rule applied",
+			rel:      "secrets/key",
+			expected: FileAttributes{Mode: 0400, Uid: 1000, Gid: 1000},
+		},
+		{
+			name:     "rule without mode keeping the default permission",
+			rel:      "run.sh",
+			expected: FileAttributes{Mode: 0640, Uid: -1, Gid: 50},
+		},
+		{
+			name:     "no matching rule",
+			rel:      "app.conf",
+			expected: FileAttributes{Mode: 0640, Uid: -1, Gid: -1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attrs := GetFileAttributes(test.rel, rules, 0640)
+			if !reflect.DeepEqual(attrs, test.expected) {
+				t.Errorf("GetFileAttributes() = %v, expected %v", attrs, test.expected)
+			}
+		})
+	}
+}
+
+func TestHasDrifted(t *testing.T) {
+	fPath := filepath.Join(t.TempDir(), "file")
+	writeFiles(t, filepath.Dir(fPath), map[string]string{"file": "content"})
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	err := os.Chtimes(fPath, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Lstat(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		attrs    FileAttributes
+		expected bool
+	}{
+		{
+			name:     "matching mode",
+			attrs:    FileAttributes{Mode: 0600, Uid: -1, Gid: -1},
+			expected: false,
+		},
+		{
+			name:     "different mode",
+			attrs:    FileAttributes{Mode: 0644, Uid: -1, Gid: -1},
+			expected: true,
+		},
+		{
+			name:     "matching modification time",
+			attrs:    FileAttributes{Mode: 0600, Uid: -1, Gid: -1, ModTime: modTime},
+			expected: false,
+		},
+		{
+			name:     "different modification time",
+			attrs:    FileAttributes{Mode: 0600, Uid: -1, Gid: -1, ModTime: modTime.Add(time.Hour)},
+			expected: true,
+		},
+		{
+			name:     "matching owner",
+			attrs:    FileAttributes{Mode: 0600, Uid: os.Getuid(), Gid: os.Getgid()},
+			expected: false,
+		},
+		{
+			name:     "different owner",
+			attrs:    FileAttributes{Mode: 0600, Uid: os.Getuid() + 1, Gid: -1},
+			expected: true,
+		},
+	}
+
+	_, _, ownerSupported := fileOwner(info)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.attrs.Uid >= 0 && !ownerSupported {
+				t.Skip("File ownership is not supported on this platform")
+			}
+
+			drifted := hasDrifted(info, test.attrs)
+			if drifted != test.expected {
+				t.Errorf("hasDrifted() = %t, expected %t", drifted, test.expected)
+			}
+		})
+	}
+}
+
+func TestEnforceFileAttributes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"drifted": "a", "kept": "b", "dated": "c"})
+	err := os.Chmod(filepath.Join(dir, "drifted"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	expected := map[string]FileAttributes{
+		"drifted": {Mode: 0600, Uid: -1, Gid: -1},
+		"kept":    {Mode: 0600, Uid: -1, Gid: -1},
+		"dated":   {Mode: 0600, Uid: -1, Gid: -1, ModTime: modTime},
+		"missing": {Mode: 0600, Uid: -1, Gid: -1},
+	}
+
+	corrected, err := EnforceFileAttributes(dir, expected)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	sort.Strings(corrected)
+	if !reflect.DeepEqual(corrected, []string{"dated", "drifted"}) {
+		t.Errorf("EnforceFileAttributes() = %v, expected [dated drifted]", corrected)
+	}
+
+	info, err := os.Lstat(filepath.Join(dir, "drifted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the mode to be corrected to 0600, got %o", info.Mode().Perm())
+	}
+
+	info, err = os.Lstat(filepath.Join(dir, "dated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("Expected the modification time to be corrected to %s, got %s", modTime, info.ModTime())
+	}
+}
@@ -0,0 +1,91 @@
+package filesystem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	Base64Suffix = ".b64"
+	GzipSuffix   = ".gz"
+	//Default maximum size of a decompressed value, protecting against values that decompress to more than the memory available
+	DefaultMaxDecodedSize = 64 * 1024 * 1024
+)
+
+func decodeBase64(value string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error decoding base64 value: %s", err.Error()))
+	}
+
+	return string(decoded), nil
+}
+
+/*
+Decompresses a gzip value, returning an error if the decompressed value is larger than the maximum size
+*/
+func decompressGzip(value string, maxSize int64) (string, error) {
+	reader, err := gzip.NewReader(strings.NewReader(value))
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error decompressing gzip value: %s", err.Error()))
+	}
+	defer reader.Close()
+
+	var decompressed bytes.Buffer
+	size, err := io.Copy(&decompressed, io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error decompressing gzip value: %s", err.Error()))
+	}
+
+	if size > maxSize {
+		return "", errors.New(fmt.Sprintf("Decompressed gzip value exceeds the maximum decoded size of %d bytes", maxSize))
+	}
+
+	return decompressed.String(), nil
+}
+
+/*
+Strips the encoding suffixes from the end of a key and decodes the value accordingly, outermost suffix first.
+For example, the value of the key "app.conf.gz.b64" is base64 decoded, then decompressed and stored in the file "app.conf".
+*/
+func DecodeKeySuffixes(key string, value string, maxSize int64) (string, string, error) {
+	originalKey := key
+	for {
+		var err error
+		switch {
+		case strings.HasSuffix(key, Base64Suffix) && len(key) > len(Base64Suffix):
+			key = strings.TrimSuffix(key, Base64Suffix)
+			value, err = decodeBase64(value)
+		case strings.HasSuffix(key, GzipSuffix) && len(key) > len(GzipSuffix):
+			key = strings.TrimSuffix(key, GzipSuffix)
+			value, err = decompressGzip(value, maxSize)
+		default:
+			return key, value, nil
+		}
+
+		if err != nil {
+			return "", "", errors.New(fmt.Sprintf("Error decoding key %s: %s", originalKey, err.Error()))
+		}
+	}
+}
+
+/*
+Returns the key without its encoding suffixes
+*/
+func StripKeySuffixes(key string) string {
+	for {
+		switch {
+		case strings.HasSuffix(key, Base64Suffix) && len(key) > len(Base64Suffix):
+			key = strings.TrimSuffix(key, Base64Suffix)
+		case strings.HasSuffix(key, GzipSuffix) && len(key) > len(GzipSuffix):
+			key = strings.TrimSuffix(key, GzipSuffix)
+		default:
+			return key
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package filesystem
+
+import (
+	"testing"
+)
+
+func TestDecodeKeySuffixes(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		value    string
+		expKey   string
+		expValue string
+		valid    bool
+	}{
+		{"no suffix", "app.conf", "plain", "app.conf", "plain", true},
+		{"base64 suffix", "app.conf.b64", encodeBase64("abc"), "app.conf", "abc", true},
+		{"stacked suffixes", "app.conf.gz.b64", encodeBase64(compress(t, "abc")), "app.conf", "abc", true},
+		{"suffix only", ".b64", "plain", ".b64", "plain", true},
+		{"suffixes stripped up to the suffix only", ".gz.b64", encodeBase64("plain"), ".gz", "plain", true},
+		{"invalid base64", "app.conf.b64", "not base64!", "", "", false},
+		{"invalid gzip", "app.conf.gz.b64", encodeBase64("not gzip"), "", "", false},
+		{"decompressed value exceeding the maximum size", "app.conf.gz.b64", encodeBase64(compress(t, "abcdefghijk")), "", "", false},
+		{"decompressed value at the maximum size", "app.conf.gz.b64", encodeBase64(compress(t, "abcdefghij")), "app.conf", "abcdefghij", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, value, err := DecodeKeySuffixes(test.key, test.value, 10)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("DecodeKeySuffixes() = %q, %q, expected an error", key, value)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if key != test.expKey || value != test.expValue {
+				t.Errorf("DecodeKeySuffixes() = %q, %q, expected %q, %q", key, value, test.expKey, test.expValue)
+			}
+		})
+	}
+}
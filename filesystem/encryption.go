@@ -0,0 +1,153 @@
+package filesystem
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	EncryptedSuffix  = ".enc"
+	EncryptionAesGcm = "aes-256-gcm"
+	decryptionKeyLen = 32
+)
+
+/*
+Loads a 256 bits key from a file containing it in either hex or base64 format
+*/
+func LoadDecryptionKey(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error reading the decryption key file: %s", err.Error()))
+	}
+
+	encoded := strings.TrimSpace(string(content))
+	key, hexErr := hex.DecodeString(encoded)
+	if hexErr != nil || len(key) != decryptionKeyLen {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != decryptionKeyLen {
+			return nil, errors.New("Decryption key file must contain a 256 bits key in hex or base64 format")
+		}
+	}
+
+	return key, nil
+}
+
+func getAesGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+/*
+Encrypts a value with AES-256-GCM for the publishing side.
+Returns the nonce followed by the ciphertext, base64 encoded.
+*/
+func Encrypt(plaintext string, key []byte) (string, error) {
+	aead, err := getAesGcm(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+/*
+Decrypts a value encrypted with AES-256-GCM, consisting of the nonce followed by the ciphertext, base64 encoded
+*/
+func Decrypt(value string, key []byte) (string, error) {
+	aead, err := getAesGcm(key)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error decoding encrypted value: %s", err.Error()))
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("Encrypted value is too short")
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("Error decrypting value: the key is wrong or the value was tampered with")
+	}
+
+	return string(plaintext), nil
+}
+
+/*
+Returns the key without its encryption suffix
+*/
+func StripEncryptedSuffix(key string) string {
+	if strings.HasSuffix(key, EncryptedSuffix) && len(key) > len(EncryptedSuffix) {
+		return strings.TrimSuffix(key, EncryptedSuffix)
+	}
+
+	return key
+}
+
+func decryptEnvelope(envelope *Envelope, key []byte) (string, error) {
+	if envelope.Encryption != EncryptionAesGcm {
+		return "", errors.New(fmt.Sprintf("Unsupported encryption '%s'", envelope.Encryption))
+	}
+
+	plaintext, err := Decrypt(envelope.Content, key)
+	if err != nil {
+		return "", err
+	}
+
+	//The plaintext may itself be compressed, in which case the encoding is kept to decompress it when the envelope is decoded
+	decrypted := *envelope
+	decrypted.Content = base64.StdEncoding.EncodeToString([]byte(plaintext))
+	if decrypted.Encoding != "gzip+base64" {
+		decrypted.Encoding = "base64"
+	}
+	decrypted.Encryption = ""
+
+	return decrypted.marshal(), nil
+}
+
+/*
+Decrypts the value of a key with the encryption suffix, stripping the suffix from the returned file, or the content of an encrypted envelope.
+A decrypted envelope is returned as an envelope with its content in plaintext.
+Other values are returned as they are.
+*/
+func DecryptValue(file string, value string, key []byte, envelopes bool) (string, string, error) {
+	origin := file
+	var err error
+
+	if strings.HasSuffix(file, EncryptedSuffix) && len(file) > len(EncryptedSuffix) {
+		file = StripEncryptedSuffix(file)
+		value, err = Decrypt(value, key)
+	} else if envelopes {
+		if envelope, ok := ParseEnvelope(value); ok && envelope.Encryption != "" {
+			value, err = decryptEnvelope(envelope, key)
+		}
+	}
+
+	if err != nil {
+		return file, value, errors.New(fmt.Sprintf("Error decrypting key %s: %s", origin, err.Error()))
+	}
+
+	return file, value, nil
+}
@@ -0,0 +1,176 @@
+package filesystem
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{1}, 32)
+
+func encryptValue(t *testing.T, plaintext string, key []byte) string {
+	encrypted, err := Encrypt(plaintext, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encrypted
+}
+
+func tamper(t *testing.T, value string) string {
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	return base64.StdEncoding.EncodeToString(sealed)
+}
+
+func TestDecrypt(t *testing.T) {
+	encrypted := encryptValue(t, "secret", testKey)
+
+	tests := []struct {
+		name     string
+		value    string
+		key      []byte
+		expected string
+		valid    bool
+	}{
+		{"round trip", encrypted, testKey, "secret", true},
+		{"surrounding whitespace", "\n" + encrypted + "\n", testKey, "secret", true},
+		{"empty plaintext", encryptValue(t, "", testKey), testKey, "", true},
+		{"wrong key", encrypted, bytes.Repeat([]byte{2}, 32), "", false},
+		{"tampered value", tamper(t, encrypted), testKey, "", false},
+		{"value that is not base64", "not base64!", testKey, "", false},
+		{"value shorter than the nonce", base64.StdEncoding.EncodeToString([]byte("short")), testKey, "", false},
+		{"key of the wrong size", encrypted, []byte("short"), "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plaintext, err := Decrypt(test.value, test.key)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("Decrypt() = %q, expected an error", plaintext)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if plaintext != test.expected {
+				t.Errorf("Decrypt() = %q, expected %q", plaintext, test.expected)
+			}
+		})
+	}
+}
+
+func TestEncryptUsesRandomNonces(t *testing.T) {
+	if encryptValue(t, "secret", testKey) == encryptValue(t, "secret", testKey) {
+		t.Errorf("Expected encrypting the same value twice to give different results")
+	}
+}
+
+func TestDecryptValue(t *testing.T) {
+	encrypted := encryptValue(t, "secret", testKey)
+	envelope := `{"envelope":1,"encryption":"aes-256-gcm","mode":"0600","content":"` + encrypted + `"}`
+	decryptedEnvelope := `{"envelope":1,"content":"` + base64.StdEncoding.EncodeToString([]byte("secret")) + `","encoding":"base64","mode":"0600"}`
+
+	tests := []struct {
+		name      string
+		file      string
+		value     string
+		envelopes bool
+		expFile   string
+		expValue  string
+		valid     bool
+	}{
+		{"encrypted suffix", "app.conf.enc", encrypted, false, "app.conf", "secret", true},
+		{"key named after the suffix only", ".enc", "plain", false, ".enc", "plain", true},
+		{"plain value", "app.conf", "plain", true, "app.conf", "plain", true},
+		{"encrypted envelope", "app.conf", envelope, true, "app.conf", decryptedEnvelope, true},
+		{"envelope left as is when envelopes are disabled", "app.conf", envelope, false, "app.conf", envelope, true},
+		{"tampered value", "app.conf.enc", tamper(t, encrypted), false, "", "", false},
+		{
+			"unsupported envelope encryption",
+			"app.conf",
+			`{"envelope":1,"encryption":"rot13","content":"` + encrypted + `"}`,
+			true,
+			"",
+			"",
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, value, err := DecryptValue(test.file, test.value, testKey, test.envelopes)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("DecryptValue() = %q, %q, expected an error", file, value)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if file != test.expFile || value != test.expValue {
+				t.Errorf("DecryptValue() = %q, %q, expected %q, %q", file, value, test.expFile, test.expValue)
+			}
+		})
+	}
+}
+
+func TestLoadDecryptionKey(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"hex", hex.EncodeToString(testKey) + "\n", true},
+		{"base64", base64.StdEncoding.EncodeToString(testKey) + "\n", true},
+		{"key that is too short", hex.EncodeToString(testKey[:16]), false},
+		{"invalid content", "not a key", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyPath := filepath.Join(dir, "key")
+			err := os.WriteFile(keyPath, []byte(test.content), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key, err := LoadDecryptionKey(keyPath)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("LoadDecryptionKey() = %v, expected an error", key)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if !bytes.Equal(key, testKey) {
+				t.Errorf("LoadDecryptionKey() = %v, expected %v", key, testKey)
+			}
+		})
+	}
+
+	_, err := LoadDecryptionKey(filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Errorf("Expected an error for a missing key file")
+	}
+}
@@ -0,0 +1,168 @@
+package filesystem
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const EnvelopeVersion = 1
+
+/*
+Structured etcd value carrying a file's content along with its metadata.
+Values are recognized as envelopes if they are json objects with the "envelope" field set to the supported version.
+The content can be encoded as "base64" or as "gzip+base64" (gzip compressed, then base64 encoded).
+If the archive field is set to "tar" or "tar.gz", the content is an archive to extract rather than the content of a file.
+If the encryption field is set to "aes-256-gcm", the content is encrypted and is decrypted before being decoded.
+*/
+type Envelope struct {
+	Envelope   int    `json:"envelope"`
+	Content    string `json:"content"`
+	Encoding   string `json:"encoding,omitempty"`
+	Mode       string `json:"mode,omitempty"`
+	User       string `json:"user,omitempty"`
+	Group      string `json:"group,omitempty"`
+	ModTime    string `json:"mtime,omitempty"`
+	Archive    string `json:"archive,omitempty"`
+	Encryption string `json:"encryption,omitempty"`
+}
+
+/*
+Content to write to a file along with the attributes specified for it by its etcd value.
+Attributes that are not specified have a zero mode, negative ids and a zero modification time.
+*/
+type FileValue struct {
+	Content    string
+	Attributes FileAttributes
+}
+
+/*
+Parses an etcd value as an envelope.
+The second return value is false if the value is not an envelope.
+*/
+func ParseEnvelope(value string) (*Envelope, bool) {
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		return nil, false
+	}
+
+	var envelope Envelope
+	err := json.Unmarshal([]byte(value), &envelope)
+	if err != nil || envelope.Envelope != EnvelopeVersion {
+		return nil, false
+	}
+
+	return &envelope, true
+}
+
+func decodeContent(content string, encoding string, maxSize int64) (string, error) {
+	switch encoding {
+	case "":
+		return content, nil
+	case "base64":
+		return decodeBase64(content)
+	case "gzip+base64":
+		compressed, err := decodeBase64(content)
+		if err != nil {
+			return "", err
+		}
+		return decompressGzip(compressed, maxSize)
+	default:
+		return "", errors.New(fmt.Sprintf("Unsupported encoding '%s'", encoding))
+	}
+}
+
+/*
+Returns the decoded content of the envelope. Compressed content larger than the maximum size once decompressed is an error.
+*/
+func (envelope *Envelope) DecodeContent(maxSize int64) (string, error) {
+	return decodeContent(envelope.Content, envelope.Encoding, maxSize)
+}
+
+/*
+Returns an envelope value carrying the given content with the same file metadata as this envelope.
+The content is base64 encoded so that it can be binary.
+*/
+func (envelope *Envelope) Wrap(content string) string {
+	wrapped := *envelope
+	wrapped.Content = base64.StdEncoding.EncodeToString([]byte(content))
+	wrapped.Encoding = "base64"
+	wrapped.Archive = ""
+
+	return wrapped.marshal()
+}
+
+func (envelope *Envelope) marshal() string {
+	value, _ := json.Marshal(envelope)
+	return string(value)
+}
+
+/*
+Returns true if the envelope specifies any file metadata
+*/
+func (envelope *Envelope) HasMetadata() bool {
+	return envelope.Mode != "" || envelope.User != "" || envelope.Group != "" || envelope.ModTime != ""
+}
+
+/*
+Decodes an etcd value into the file content and attributes it specifies.
+If envelopes are not enabled or the value is not an envelope, the value is the content as is.
+*/
+func DecodeValue(value string, envelopes bool, maxSize int64) (FileValue, error) {
+	decoded := FileValue{
+		Content:    value,
+		Attributes: FileAttributes{Mode: 0, Uid: -1, Gid: -1},
+	}
+
+	if !envelopes {
+		return decoded, nil
+	}
+
+	envelope, ok := ParseEnvelope(value)
+	if !ok {
+		return decoded, nil
+	}
+
+	if envelope.Encryption != "" {
+		return decoded, errors.New("Envelope is encrypted, but no decryption key is configured")
+	}
+
+	content, err := envelope.DecodeContent(maxSize)
+	if err != nil {
+		return decoded, err
+	}
+	decoded.Content = content
+
+	if envelope.Mode != "" {
+		mode, modeErr := strconv.ParseUint(envelope.Mode, 8, 32)
+		if modeErr != nil || mode > 511 {
+			return decoded, errors.New(fmt.Sprintf("Envelope mode '%s' is not a valid unix value for file permissions", envelope.Mode))
+		}
+		decoded.Attributes.Mode = ConvertFileMode(envelope.Mode)
+	}
+
+	uid, uidErr := ResolveUid(envelope.User)
+	if uidErr != nil {
+		return decoded, errors.New(fmt.Sprintf("Error resolving envelope user '%s': %s", envelope.User, uidErr.Error()))
+	}
+	decoded.Attributes.Uid = uid
+
+	gid, gidErr := ResolveGid(envelope.Group)
+	if gidErr != nil {
+		return decoded, errors.New(fmt.Sprintf("Error resolving envelope group '%s': %s", envelope.Group, gidErr.Error()))
+	}
+	decoded.Attributes.Gid = gid
+
+	if envelope.ModTime != "" {
+		modTime, timeErr := time.Parse(time.RFC3339Nano, envelope.ModTime)
+		if timeErr != nil {
+			return decoded, errors.New(fmt.Sprintf("Envelope mtime '%s' is not a valid RFC3339 timestamp", envelope.ModTime))
+		}
+		decoded.Attributes.ModTime = modTime
+	}
+
+	return decoded, nil
+}
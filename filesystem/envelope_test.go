@@ -0,0 +1,131 @@
+package filesystem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func compress(t *testing.T, content string) string {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return compressed.String()
+}
+
+func encodeBase64(content string) string {
+	return base64.StdEncoding.EncodeToString([]byte(content))
+}
+
+func TestDecodeValue(t *testing.T) {
+	defaultAttrs := FileAttributes{Mode: 0, Uid: -1, Gid: -1}
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		value     string
+		envelopes bool
+		expected  FileValue
+		valid     bool
+	}{
+		{
+			name:      "plain value",
+			value:     "plain",
+			envelopes: true,
+			expected:  FileValue{Content: "plain", Attributes: defaultAttrs},
+			valid:     true,
+		},
+		{
+			name:      "envelope left as is when envelopes are disabled",
+			value:     `{"envelope":1,"content":"abc","mode":"0600"}`,
+			envelopes: false,
+			expected:  FileValue{Content: `{"envelope":1,"content":"abc","mode":"0600"}`, Attributes: defaultAttrs},
+			valid:     true,
+		},
+		{
+			name:      "json value that is not an envelope",
+			value:     `{"envelope":2,"content":"abc"}`,
+			envelopes: true,
+			expected:  FileValue{Content: `{"envelope":2,"content":"abc"}`, Attributes: defaultAttrs},
+			valid:     true,
+		},
+		{
+			name:      "envelope with metadata",
+			value:     `{"envelope":1,"content":"abc","mode":"0640","user":"1000","group":"50","mtime":"2024-01-02T03:04:05Z"}`,
+			envelopes: true,
+			expected:  FileValue{Content: "abc", Attributes: FileAttributes{Mode: 0640, Uid: 1000, Gid: 50, ModTime: modTime}},
+			valid:     true,
+		},
+		{
+			name:      "base64 envelope",
+			value:     `{"envelope":1,"content":"` + encodeBase64("abc") + `","encoding":"base64"}`,
+			envelopes: true,
+			expected:  FileValue{Content: "abc", Attributes: defaultAttrs},
+			valid:     true,
+		},
+		{
+			name:      "gzip envelope",
+			value:     `{"envelope":1,"content":"` + encodeBase64(compress(t, "abc")) + `","encoding":"gzip+base64"}`,
+			envelopes: true,
+			expected:  FileValue{Content: "abc", Attributes: defaultAttrs},
+			valid:     true,
+		},
+		{
+			name:      "gzip envelope exceeding the maximum size",
+			value:     `{"envelope":1,"content":"` + encodeBase64(compress(t, "abcdefghijk")) + `","encoding":"gzip+base64"}`,
+			envelopes: true,
+		},
+		{
+			name:      "unsupported encoding",
+			value:     `{"envelope":1,"content":"abc","encoding":"rot13"}`,
+			envelopes: true,
+		},
+		{
+			name:      "invalid mode",
+			value:     `{"envelope":1,"content":"abc","mode":"0999"}`,
+			envelopes: true,
+		},
+		{
+			name:      "invalid mtime",
+			value:     `{"envelope":1,"content":"abc","mtime":"yesterday"}`,
+			envelopes: true,
+		},
+		{
+			name:      "encrypted envelope",
+			value:     `{"envelope":1,"content":"abc","encryption":"aes-256-gcm"}`,
+			envelopes: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, err := DecodeValue(test.value, test.envelopes, 10)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("DecodeValue() = %v, expected an error", decoded)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(decoded, test.expected) {
+				t.Errorf("DecodeValue() = %v, expected %v", decoded, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+Atomically exchanges two existing paths.
+Falls back to consecutive renames on filesystems that do not support exchanges.
+*/
+func exchangePaths(first string, second string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, first, unix.AT_FDCWD, second, unix.RENAME_EXCHANGE)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+		return renamePaths(first, second)
+	}
+
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package filesystem
+
+/*
+Exchanges two existing paths.
+There is no portable atomic exchange outside of Linux, so the second path is briefly absent.
+*/
+func exchangePaths(first string, second string) error {
+	return renamePaths(first, second)
+}
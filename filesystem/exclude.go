@@ -0,0 +1,57 @@
+package filesystem
+
+import (
+	"path"
+	"strings"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+/*
+Returns whether a relative path with forward slashes matches a glob pattern.
+Patterns without a slash are matched against each component of the path (ex: "*.swp" or ".git") while patterns with a slash are matched against the path from the root (ex: "cache/*").
+A path also matches if any of its parent directories does.
+*/
+func MatchesPattern(rel string, pattern string) bool {
+	components := strings.Split(rel, "/")
+	pattern = strings.Trim(pattern, "/")
+
+	if !strings.Contains(pattern, "/") {
+		for _, component := range components {
+			if matched, _ := path.Match(pattern, component); matched {
+				return true
+			}
+		}
+		return false
+	}
+
+	for idx := range components {
+		if matched, _ := path.Match(pattern, strings.Join(components[:idx+1], "/")); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Returns whether a relative path with forward slashes matches any of the exclusion glob patterns
+*/
+func IsExcluded(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if MatchesPattern(rel, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Returns a key filter that only keeps the keys that are not excluded
+*/
+func GetExcludeFilter(patterns []string) client.KeyDiffFilter {
+	return func(key string) bool {
+		return !IsExcluded(key, patterns)
+	}
+}
@@ -0,0 +1,47 @@
+package filesystem
+
+import (
+	"testing"
+)
+
+func TestIsExcluded(t *testing.T) {
+	tests := []struct {
+		name     string
+		rel      string
+		patterns []string
+		expected bool
+	}{
+		{"no patterns", "app.conf", []string{}, false},
+		{"base name glob", "app.conf.swp", []string{"*.swp"}, true},
+		{"base name glob in subdirectory", "dir/app.conf.swp", []string{"*.swp"}, true},
+		{"base name glob matching a parent directory", ".git/config", []string{".git"}, true},
+		{"base name glob not matching", "app.conf", []string{"*.swp"}, false},
+		{"rooted pattern", "cache/data", []string{"cache/*"}, true},
+		{"rooted pattern matching a parent directory", "cache/dir/data", []string{"cache/*"}, true},
+		{"rooted pattern only matches from the root", "dir/cache/data", []string{"cache/*"}, false},
+		{"rooted pattern with surrounding slashes", "cache/data", []string{"/cache/data/"}, true},
+		{"any of several patterns", "app.tmp", []string{"*.swp", "*.tmp"}, true},
+		{"partial component is not a match", "gitignore", []string{"git"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := IsExcluded(test.rel, test.patterns)
+			if result != test.expected {
+				t.Errorf("IsExcluded(%q, %v) = %t, expected %t", test.rel, test.patterns, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetExcludeFilter(t *testing.T) {
+	filter := GetExcludeFilter([]string{"*.swp"})
+
+	if filter("app.conf.swp") {
+		t.Errorf("Excluded key was kept by the filter")
+	}
+
+	if !filter("app.conf") {
+		t.Errorf("Key that is not excluded was filtered out")
+	}
+}
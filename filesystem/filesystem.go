@@ -4,23 +4,41 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
 )
 
-func WatchInfoToKeyDiffs(filesystemPath string, w client.WatchInfo) (client.KeyDiff, error) {
+/*
+Converts the changes reported by an etcd watch to the modifications to do on the directory.
+Upserts whose decoded content and attributes are identical to the existing file and deletions of files that do not exist are dropped as they are no-ops.
+*/
+func WatchInfoToKeyDiffs(filesystemPath string, w client.WatchInfo, opts ApplyOptions) (client.KeyDiff, error) {
 	diff := client.KeyDiff{
 		Inserts:   make(map[string]string),
 		Updates:   make(map[string]string),
-		Deletions: w.Deletions,
+		Deletions: []string{},
+	}
+
+	for _, key := range w.Deletions {
+		_, err := os.Lstat(filepath.Join(filesystemPath, key))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return diff, err
+			}
+
+			continue
+		}
+
+		diff.Deletions = append(diff.Deletions, key)
 	}
 
 	for key, val := range w.Upserts {
-		_, err := os.Stat(filepath.Join(filesystemPath, key))
+		fPath := filepath.Join(filesystemPath, key)
+		hash, err := HashFile(fPath)
 		if err != nil {
 			if !errors.Is(err, os.ErrNotExist) {
 				return diff, err
@@ -30,7 +48,24 @@ func WatchInfoToKeyDiffs(filesystemPath string, w client.WatchInfo) (client.KeyD
 			continue
 		}
 
-		diff.Updates[key] = val.Value
+		content, attrs, resolveErr := opts.ResolveValue(key, val.Value)
+		if resolveErr != nil {
+			return diff, resolveErr
+		}
+
+		if hash != HashContent(content) {
+			diff.Updates[key] = val.Value
+			continue
+		}
+
+		info, statErr := os.Lstat(fPath)
+		if statErr != nil {
+			return diff, statErr
+		}
+
+		if hasDrifted(info, attrs) {
+			diff.Updates[key] = val.Value
+		}
 	}
 
 	return diff, nil
@@ -57,37 +92,205 @@ func EnsureFilesystemDir(filesystemPath string, permissions os.FileMode) error {
 	return nil
 }
 
-func GetDirectoryContent(path string) (client.KeyInfoMap, error) {
-	keys := client.KeyInfoMap(map[string]client.KeyInfo{})
+/*
+Writes the content to a temporary file in the same directory as the destination and then renames it over the destination.
+Readers of the destination will either see the previous content or the new content, never a partially written file.
+*/
+func WriteFileAtomically(fPath string, content []byte, permission os.FileMode) error {
+	return WriteFileAtomicallyWithAttributes(fPath, content, FileAttributes{Mode: permission, Uid: -1, Gid: -1})
+}
 
-	err := filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+/*
+Same as WriteFileAtomically, but also sets the ownership of the file if the attributes specify it
+*/
+func WriteFileAtomicallyWithAttributes(fPath string, content []byte, attrs FileAttributes) error {
+	f, err := os.CreateTemp(filepath.Dir(fPath), "."+filepath.Base(fPath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	cleanup := func(err error) error {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	_, err = f.Write(content)
+	if err != nil {
+		return cleanup(err)
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return cleanup(err)
+	}
+
+	err = applyAttributes(f, attrs)
+	if err != nil {
+		return cleanup(err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, fPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+/*
+Options controlling how a diff is applied to a directory
+*/
+type ApplyOptions struct {
+	FilesPermission       os.FileMode
+	DirectoriesPermission os.FileMode
+	//If not nil, only the files listed in the manifest will be overwritten or deleted and the manifest will be updated with the changes
+	Manifest *Manifest
+	//Glob patterns of files that should never be overwritten or deleted
+	Exclude []string
+	//Ordered rules overriding the files permission and ownership for the files matching their pattern
+	PermissionRules []PermissionRule
+	//If true, values that are envelopes are decoded and the metadata they specify overrides the permission rules
+	Envelopes bool
+	//Maximum size of compressed envelope content once decompressed. Defaults to DefaultMaxDecodedSize if zero
+	MaxDecodedSize int64
+	//Directories, relative with forward slashes, whose content is swapped as a whole when the diff changes it (ex: extracted archives)
+	AtomicDirectories []string
+}
+
+func (opts *ApplyOptions) GetMaxDecodedSize() int64 {
+	if opts.MaxDecodedSize <= 0 {
+		return DefaultMaxDecodedSize
+	}
+
+	return opts.MaxDecodedSize
+}
+
+/*
+Returns the content to write for a value and the attributes to give to the file
+*/
+func (opts *ApplyOptions) ResolveValue(rel string, value string) (string, FileAttributes, error) {
+	decoded, err := DecodeValue(value, opts.Envelopes, opts.GetMaxDecodedSize())
+	if err != nil {
+		return "", FileAttributes{}, errors.New(fmt.Sprintf("Error decoding value of key %s: %s", rel, err.Error()))
+	}
+
+	attrs := GetFileAttributes(rel, opts.PermissionRules, opts.FilesPermission)
+	return decoded.Content, mergeAttributes(attrs, decoded.Attributes), nil
+}
+
+/*
+Applies the diff to the directory.
+The files the diff touches are backed up beforehand and if any operation fails, the directory is restored to its previous state before the error is returned.
+*/
+func ApplyDiffToDirectory(path string, diff client.KeyDiff, opts ApplyOptions) error {
+	return applyDiff(path, diff, opts, true)
+}
+
+/*
+Applies the diff to the directory, backing up the files it touches only if the changes should be rolled back on failure.
+Directories that are thrown away on failure, such as the staging versions of swap mode, do not need a backup.
+*/
+func applyDiff(path string, diff client.KeyDiff, opts ApplyOptions, rollback bool) error {
+	diff = *diff.FilterKeys(GetExcludeFilter(opts.Exclude))
+
+	keysErr := checkDiffKeys(path, diff)
+	if keysErr != nil {
+		return keysErr
+	}
+
+	if opts.Manifest != nil {
+		checked, checkErr := opts.Manifest.CheckDiff(path, diff)
+		if checkErr != nil {
+			return checkErr
 		}
+		diff = checked
+	}
 
-		if !entry.IsDir() {
-			content, err := ioutil.ReadFile(path)
-			if err != nil {
-				return err
-			}
+	touched := []string{}
+	for _, file := range diff.Deletions {
+		touched = append(touched, filepath.Join(path, filepath.FromSlash(file)))
+	}
+	for file, _ := range diff.Inserts {
+		touched = append(touched, filepath.Join(path, filepath.FromSlash(file)))
+	}
+	for file, _ := range diff.Updates {
+		touched = append(touched, filepath.Join(path, filepath.FromSlash(file)))
+	}
 
-			keys[path] = client.KeyInfo{
-				Key:            filepath.ToSlash(path),
-				Value:          string(content),
-				Version:        0,
-				CreateRevision: 0,
-				ModRevision:    0,
-				Lease:          0,
-			}
+	var snapshot *directorySnapshot
+	if rollback {
+		var snapErr error
+		snapshot, snapErr = snapshotFiles(path, touched, opts.DirectoriesPermission)
+		if snapErr != nil {
+			return snapErr
 		}
+	}
 
-		return nil
-	})
+	var previousManifest map[string]bool
+	if opts.Manifest != nil {
+		//Files are added to the manifest before being written so that a crash never leaves behind written files that are not managed
+		previousManifest = opts.Manifest.Copy()
+		for file, _ := range diff.Inserts {
+			opts.Manifest.Files[file] = true
+		}
+		for file, _ := range diff.Updates {
+			opts.Manifest.Files[file] = true
+		}
+
+		saveErr := opts.Manifest.Save()
+		if saveErr != nil {
+			opts.Manifest.Files = previousManifest
+			snapshot.discard()
+			return saveErr
+		}
+	}
+
+	applyErr := applyDiffOperations(path, diff, opts, snapshot)
+	if applyErr != nil {
+		if snapshot == nil {
+			return errors.New(fmt.Sprintf("Error applying diff to directory: %s", applyErr.Error()))
+		}
+
+		restoreErr := snapshot.restore()
+		if restoreErr == nil && opts.Manifest != nil {
+			opts.Manifest.Files = previousManifest
+			restoreErr = opts.Manifest.Save()
+		}
+
+		if restoreErr != nil {
+			return errors.New(fmt.Sprintf("Error applying diff to directory: %s. Additionally, failed to rollback the changes: %s", applyErr.Error(), restoreErr.Error()))
+		}
+
+		return errors.New(fmt.Sprintf("Error applying diff to directory, changes were rolled back: %s", applyErr.Error()))
+	}
 
-	return keys, err
+	if opts.Manifest != nil && len(diff.Deletions) > 0 {
+		for _, file := range diff.Deletions {
+			delete(opts.Manifest.Files, file)
+		}
+
+		saveErr := opts.Manifest.Save()
+		if saveErr != nil {
+			snapshot.discard()
+			return saveErr
+		}
+	}
+
+	return snapshot.discard()
 }
 
-func ApplyDiffToDirectory(path string, diff client.KeyDiff, filesPermission os.FileMode, dirPermission os.FileMode) error {
+func applyDiffOperations(path string, diff client.KeyDiff, opts ApplyOptions, snapshot *directorySnapshot) error {
+	trees, diff := splitTreeDiffs(diff, opts.AtomicDirectories)
+
 	for _, file := range diff.Deletions {
 		fPath := filepath.Join(path, filepath.FromSlash(file))
 		err := os.Remove(fPath)
@@ -99,48 +302,138 @@ func ApplyDiffToDirectory(path string, diff client.KeyDiff, filesPermission os.F
 	upsertFile := func(file string, content string) error {
 		fPath := filepath.Join(path, filepath.FromSlash(file))
 		fdir := filepath.Dir(fPath)
-		mkdirErr := os.MkdirAll(fdir, dirPermission)
+		snapshot.trackDir(fdir)
+		mkdirErr := os.MkdirAll(fdir, opts.DirectoriesPermission)
 		if mkdirErr != nil {
 			return mkdirErr
 		}
 
-		f, err := os.OpenFile(fPath, os.O_RDWR|os.O_CREATE, filesPermission)
+		decoded, attrs, err := opts.ResolveValue(file, content)
 		if err != nil {
 			return err
 		}
 
-		err = f.Truncate(0)
+		return WriteFileAtomicallyWithAttributes(fPath, []byte(decoded), attrs)
+	}
+
+	for file, content := range diff.Inserts {
+		err := upsertFile(file, content)
 		if err != nil {
-			f.Close()
 			return err
 		}
+	}
 
-		_, err = f.Write([]byte(content))
+	for file, content := range diff.Updates {
+		err := upsertFile(file, content)
 		if err != nil {
-			f.Close()
 			return err
 		}
+	}
 
-		if err := f.Close(); err != nil {
+	for _, file := range diff.Deletions {
+		fPath := filepath.Join(path, filepath.FromSlash(file))
+		err := pruneEmptyParents(path, filepath.Dir(fPath))
+		if err != nil {
 			return err
 		}
-
-		return nil
 	}
 
-	for file, content := range diff.Inserts {
-		err := upsertFile(file, content)
+	for dir, tree := range trees {
+		err := replaceTree(path, dir, tree, opts, snapshot)
 		if err != nil {
 			return err
 		}
 	}
 
-	for file, content := range diff.Updates {
-		err := upsertFile(file, content)
+	return nil
+}
+
+func isDirEmpty(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	return len(entries) == 0, nil
+}
+
+/*
+Removes the directory and its parents for as long as they are empty, stopping at the root which is never removed
+*/
+func pruneEmptyParents(root string, dir string) error {
+	for dir != root && strings.HasPrefix(dir, root) {
+		empty, err := isDirEmpty(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				dir = filepath.Dir(dir)
+				continue
+			}
+			return err
+		}
+
+		if !empty {
+			return nil
+		}
+
+		err = os.Remove(dir)
 		if err != nil {
 			return err
 		}
+
+		dir = filepath.Dir(dir)
 	}
 
 	return nil
 }
+
+/*
+Removes all the empty directories under the path, excluding the path itself, rollback directories and directories matching the exclusion patterns.
+Returns the directories that were removed.
+*/
+func RemoveEmptyDirectories(path string, exclude []string) ([]string, error) {
+	dirs := []string{}
+	err := filepath.WalkDir(path, func(dir string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() && dir != path {
+			rel, relErr := filepath.Rel(path, dir)
+			if relErr != nil {
+				return relErr
+			}
+
+			if IsExcluded(filepath.ToSlash(rel), exclude) || IsRollbackPath(filepath.ToSlash(rel)) {
+				return filepath.SkipDir
+			}
+		}
+
+		if entry.IsDir() && dir != path {
+			dirs = append(dirs, dir)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	//Walk order is lexical, so iterating backward visits subdirectories before their parents
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		empty, emptyErr := isDirEmpty(dirs[idx])
+		if emptyErr != nil {
+			return removed, emptyErr
+		}
+
+		if empty {
+			rmErr := os.Remove(dirs[idx])
+			if rmErr != nil {
+				return removed, rmErr
+			}
+			removed = append(removed, dirs[idx])
+		}
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,125 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for file, content := range files {
+		fPath := filepath.Join(dir, filepath.FromSlash(file))
+		err := os.MkdirAll(filepath.Dir(fPath), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(fPath, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFiles(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(fPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, relErr := filepath.Rel(dir, fPath)
+		if relErr != nil {
+			return relErr
+		}
+
+		content, readErr := os.ReadFile(fPath)
+		if readErr != nil {
+			return readErr
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestApplyDiffToDirectoryRollsBackOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"deleted":   "deleted",
+		"updated":   "updated",
+		"sub/kept":  "kept",
+		"not-a-dir": "file",
+	}
+	writeFiles(t, dir, files)
+
+	//The invalid envelope fails to decode once the deletions and possibly the other upserts were applied
+	diff := client.KeyDiff{
+		Inserts:   map[string]string{"new/file": "new", "invalid": `{"envelope": 1, "content": "x", "encoding": "unknown"}`},
+		Updates:   map[string]string{"updated": "new"},
+		Deletions: []string{"deleted", "sub/kept"},
+	}
+	err := ApplyDiffToDirectory(dir, diff, ApplyOptions{FilesPermission: 0600, DirectoriesPermission: 0700, Envelopes: true})
+	if err == nil || !strings.Contains(err.Error(), "changes were rolled back") {
+		t.Fatalf("Expected the changes to be rolled back, got %v", err)
+	}
+
+	restored := readFiles(t, dir)
+	if !reflect.DeepEqual(restored, files) {
+		t.Errorf("Directory = %v after the failure, expected %v", restored, files)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "new"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected the directory created by the diff to be removed, got %v", err)
+	}
+}
+
+func TestWatchInfoToKeyDiffs(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"unchanged": "same",
+		"changed":   "old",
+		"drifted":   "same",
+		"deleted":   "deleted",
+	})
+	err := os.Chmod(filepath.Join(dir, "drifted"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := client.WatchInfo{
+		Upserts: map[string]client.WatchKeyInfo{
+			"unchanged": {Value: "same"},
+			"changed":   {Value: "new"},
+			"drifted":   {Value: "same"},
+			"new":       {Value: "new"},
+		},
+		Deletions: []string{"deleted", "missing"},
+	}
+
+	diff, err := WatchInfoToKeyDiffs(dir, changes, ApplyOptions{FilesPermission: 0600, DirectoriesPermission: 0700})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	expected := client.KeyDiff{
+		Inserts:   map[string]string{"new": "new"},
+		Updates:   map[string]string{"changed": "new", "drifted": "same"},
+		Deletions: []string{"deleted"},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("WatchInfoToKeyDiffs() = %v, expected %v", diff, expected)
+	}
+}
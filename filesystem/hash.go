@@ -0,0 +1,141 @@
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+/*
+Returns the hex encoded sha256 hash of the content
+*/
+func HashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+Returns the hex encoded sha256 hash of a file's content, streaming the file rather than loading it in memory.
+Symlinks are not followed and are given an empty hash, which never matches any content.
+*/
+func HashFile(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		return "", nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	//Guards against the file being swapped for a symlink after it was inspected
+	openedInfo, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if !os.SameFile(info, openedInfo) {
+		return "", errors.New(fmt.Sprintf("File %s changed while being opened", path))
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+/*
+Returns the content hashes of the files in the directory, keyed by their path relative to the directory with forward slashes.
+Files and directories that match the exclusion patterns are skipped, as are rollback directories.
+*/
+func GetDirectoryHashes(path string, exclude []string) (map[string]string, error) {
+	hashes := map[string]string{}
+
+	err := filepath.WalkDir(path, func(fPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fPath == path {
+			return nil
+		}
+
+		rel, relErr := filepath.Rel(path, fPath)
+		if relErr != nil {
+			return relErr
+		}
+		rel = filepath.ToSlash(rel)
+
+		if IsExcluded(rel, exclude) || IsRollbackPath(rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !entry.IsDir() {
+			hash, hashErr := HashFile(fPath)
+			if hashErr != nil {
+				return hashErr
+			}
+			hashes[rel] = hash
+		}
+
+		return nil
+	})
+
+	return hashes, err
+}
+
+/*
+Returns the modifications to do on a directory, given its content hashes, to make it like the source key/value pairs.
+Source values are decoded according to the options and the hash of each is computed only once.
+*/
+func GetHashDiff(src map[string]string, dstHashes map[string]string, opts ApplyOptions) (client.KeyDiff, error) {
+	diff := client.KeyDiff{
+		Inserts:   make(map[string]string),
+		Updates:   make(map[string]string),
+		Deletions: []string{},
+	}
+
+	for key, _ := range dstHashes {
+		if _, ok := src[key]; !ok {
+			diff.Deletions = append(diff.Deletions, key)
+		}
+	}
+
+	for key, srcVal := range src {
+		dstHash, ok := dstHashes[key]
+		if !ok {
+			diff.Inserts[key] = srcVal
+			continue
+		}
+
+		content, _, err := opts.ResolveValue(key, srcVal)
+		if err != nil {
+			return diff, err
+		}
+
+		if dstHash != HashContent(content) {
+			diff.Updates[key] = srcVal
+		}
+	}
+
+	return diff, nil
+}
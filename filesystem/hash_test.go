@@ -0,0 +1,125 @@
+package filesystem
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+func TestGetHashDiff(t *testing.T) {
+	envelope := `{"envelope":1,"content":"` + base64.StdEncoding.EncodeToString([]byte("same")) + `","encoding":"base64"}`
+
+	tests := []struct {
+		name      string
+		src       map[string]string
+		dstHashes map[string]string
+		envelopes bool
+		expected  client.KeyDiff
+	}{
+		{
+			name:      "identical",
+			src:       map[string]string{"a": "same"},
+			dstHashes: map[string]string{"a": HashContent("same")},
+			expected:  client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{}, Deletions: []string{}},
+		},
+		{
+			name:      "insert, update and deletion",
+			src:       map[string]string{"new": "new", "changed": "after"},
+			dstHashes: map[string]string{"changed": HashContent("before"), "removed": HashContent("removed")},
+			expected: client.KeyDiff{
+				Inserts:   map[string]string{"new": "new"},
+				Updates:   map[string]string{"changed": "after"},
+				Deletions: []string{"removed"},
+			},
+		},
+		{
+			name:      "envelope compared by its decoded content",
+			src:       map[string]string{"a": envelope},
+			dstHashes: map[string]string{"a": HashContent("same")},
+			envelopes: true,
+			expected:  client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{}, Deletions: []string{}},
+		},
+		{
+			name:      "envelope compared as is when envelopes are disabled",
+			src:       map[string]string{"a": envelope},
+			dstHashes: map[string]string{"a": HashContent("same")},
+			expected:  client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{"a": envelope}, Deletions: []string{}},
+		},
+		{
+			name:      "symlink hash never matches",
+			src:       map[string]string{"a": ""},
+			dstHashes: map[string]string{"a": ""},
+			expected:  client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{"a": ""}, Deletions: []string{}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff, err := GetHashDiff(test.src, test.dstHashes, ApplyOptions{Envelopes: test.envelopes})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			sort.Strings(diff.Deletions)
+			if !reflect.DeepEqual(diff, test.expected) {
+				t.Errorf("GetHashDiff() = %v, expected %v", diff, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetHashDiffInvalidEnvelope(t *testing.T) {
+	src := map[string]string{"a": `{"envelope":1,"content":"not base64!","encoding":"base64"}`}
+
+	_, err := GetHashDiff(src, map[string]string{"a": HashContent("a")}, ApplyOptions{Envelopes: true})
+	if err == nil {
+		t.Errorf("Expected an error for an envelope that cannot be decoded")
+	}
+}
+
+func TestGetDirectoryHashes(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"a":            "a",
+		"sub/b":        "b",
+		"sub/c.swp":    "c",
+		"cache/d":      "d",
+		"cache/nested": "e",
+	}
+	for file, content := range files {
+		fPath := filepath.Join(dir, filepath.FromSlash(file))
+		err := os.MkdirAll(filepath.Dir(fPath), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(fPath, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := os.Symlink("a", filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes, err := GetDirectoryHashes(dir, []string{"*.swp", "cache"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	expected := map[string]string{
+		"a":     HashContent("a"),
+		"sub/b": HashContent("b"),
+		"link":  "",
+	}
+	if !reflect.DeepEqual(hashes, expected) {
+		t.Errorf("GetDirectoryHashes() = %v, expected %v", hashes, expected)
+	}
+}
@@ -0,0 +1,180 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+const ManifestFile = "..manifest"
+
+/*
+List of the files written by the updater in a directory, as relative paths with forward slashes.
+It is persisted in the directory under a reserved name so that files placed there by other tools can be told apart.
+*/
+type Manifest struct {
+	Path  string
+	Files map[string]bool
+}
+
+func LoadManifest(dirPath string) (*Manifest, error) {
+	manifest := Manifest{
+		Path:  filepath.Join(dirPath, ManifestFile),
+		Files: map[string]bool{},
+	}
+
+	content, err := os.ReadFile(manifest.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &manifest, nil
+		}
+
+		return nil, errors.New(fmt.Sprintf("Error reading managed files manifest: %s", err.Error()))
+	}
+
+	files := []string{}
+	err = json.Unmarshal(content, &files)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error parsing managed files manifest: %s", err.Error()))
+	}
+
+	for _, file := range files {
+		manifest.Files[file] = true
+	}
+
+	return &manifest, nil
+}
+
+func (m *Manifest) Contains(file string) bool {
+	return m.Files[file]
+}
+
+func (m *Manifest) Save() error {
+	files := []string{}
+	for file, _ := range m.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	content, err := json.Marshal(files)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error serializing managed files manifest: %s", err.Error()))
+	}
+
+	err = WriteFileAtomically(m.Path, content, 0600)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error writing managed files manifest: %s", err.Error()))
+	}
+
+	return nil
+}
+
+/*
+Returns a copy of the manifest's file list so that it can be restored later
+*/
+func (m *Manifest) Copy() map[string]bool {
+	files := map[string]bool{}
+	for file, _ := range m.Files {
+		files[file] = true
+	}
+	return files
+}
+
+/*
+Only keeps the values whose key is a managed file
+*/
+func (m *Manifest) FilterValues(values map[string]string) map[string]string {
+	filtered := map[string]string{}
+	for key, val := range values {
+		if m.Contains(key) {
+			filtered[key] = val
+		}
+	}
+	return filtered
+}
+
+/*
+Drops the deletions of files that are not managed and returns an error if the diff would overwrite an existing file that is not managed
+*/
+func (m *Manifest) CheckDiff(path string, diff client.KeyDiff) (client.KeyDiff, error) {
+	checked := client.KeyDiff{
+		Inserts:   diff.Inserts,
+		Updates:   diff.Updates,
+		Deletions: []string{},
+	}
+
+	for _, file := range diff.Deletions {
+		if m.Contains(file) {
+			checked.Deletions = append(checked.Deletions, file)
+		}
+	}
+
+	checkUpsert := func(file string) error {
+		if m.Contains(file) {
+			return nil
+		}
+
+		_, err := os.Lstat(filepath.Join(path, filepath.FromSlash(file)))
+		if err == nil {
+			return errors.New(fmt.Sprintf("Refusing to overwrite file %s which is not managed by the updater", file))
+		}
+
+		return nil
+	}
+
+	for file, _ := range diff.Inserts {
+		err := checkUpsert(file)
+		if err != nil {
+			return checked, err
+		}
+	}
+
+	for file, _ := range diff.Updates {
+		err := checkUpsert(file)
+		if err != nil {
+			return checked, err
+		}
+	}
+
+	return checked, nil
+}
+
+/*
+Adds to the manifest the files that are not managed yet, but whose content already matches the value they should have.
+This allows the manifest to be enabled on a directory that was already synchronized, without overwriting any file.
+Returns the files that were added.
+*/
+func (m *Manifest) AdoptMatchingFiles(values map[string]string, dirHashes map[string]string, opts ApplyOptions) ([]string, error) {
+	adopted := []string{}
+	for file, val := range values {
+		hash, exists := dirHashes[file]
+		if !exists || m.Contains(file) {
+			continue
+		}
+
+		content, _, err := opts.ResolveValue(file, val)
+		if err != nil {
+			return []string{}, err
+		}
+
+		if hash == HashContent(content) {
+			adopted = append(adopted, file)
+		}
+	}
+
+	if len(adopted) == 0 {
+		return adopted, nil
+	}
+
+	for _, file := range adopted {
+		m.Files[file] = true
+	}
+	sort.Strings(adopted)
+
+	return adopted, m.Save()
+}
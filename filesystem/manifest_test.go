@@ -0,0 +1,114 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+func TestAdoptMatchingFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Files["managed"] = true
+
+	values := map[string]string{
+		"matching":  "same",
+		"different": "new",
+		"missing":   "missing",
+		"managed":   "same",
+	}
+	dirHashes := map[string]string{
+		"matching":  HashContent("same"),
+		"different": HashContent("old"),
+		"managed":   HashContent("old"),
+		"other":     HashContent("other"),
+	}
+
+	adopted, err := manifest.AdoptMatchingFiles(values, dirHashes, ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(adopted, []string{"matching"}) {
+		t.Errorf("AdoptMatchingFiles() = %v, expected [matching]", adopted)
+	}
+
+	loaded, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{"managed": true, "matching": true}
+	if !reflect.DeepEqual(loaded.Files, expected) {
+		t.Errorf("Saved manifest = %v, expected %v", loaded.Files, expected)
+	}
+}
+
+func TestCheckDiff(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{"managed", "unmanaged"} {
+		err := os.WriteFile(filepath.Join(dir, file), []byte(file), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := Manifest{Path: filepath.Join(dir, ManifestFile), Files: map[string]bool{"managed": true}}
+
+	tests := []struct {
+		name      string
+		diff      client.KeyDiff
+		deletions []string
+		valid     bool
+	}{
+		{
+			name:      "deletions of unmanaged files dropped",
+			diff:      client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{}, Deletions: []string{"managed", "unmanaged"}},
+			deletions: []string{"managed"},
+			valid:     true,
+		},
+		{
+			name:      "managed file overwritten",
+			diff:      client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{"managed": "new"}, Deletions: []string{}},
+			deletions: []string{},
+			valid:     true,
+		},
+		{
+			name:      "new file",
+			diff:      client.KeyDiff{Inserts: map[string]string{"new": "new"}, Updates: map[string]string{}, Deletions: []string{}},
+			deletions: []string{},
+			valid:     true,
+		},
+		{
+			name: "unmanaged file overwritten",
+			diff: client.KeyDiff{Inserts: map[string]string{"unmanaged": "new"}, Updates: map[string]string{}, Deletions: []string{}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checked, err := manifest.CheckDiff(dir, test.diff)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("CheckDiff() = %v, expected an error", checked)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(checked.Deletions, test.deletions) {
+				t.Errorf("CheckDiff() deletions = %v, expected %v", checked.Deletions, test.deletions)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	RewriteRegex          = "regex"
+	RewriteStripExtension = "strip_extension"
+	RewriteFlatten        = "flatten"
+)
+
+/*
+Rule rewriting the path a key is written to.
+Rules of the "regex" type replace the matches of their pattern with the replacement, which can refer to capture groups (ex: $1).
+Rules of the "strip_extension" type remove the given extension, or any extension if none is given.
+Rules of the "flatten" type remove the directories, keeping only the file name, or join the path components with the separator if one is given.
+*/
+type RewriteRule struct {
+	Type string
+	//If not nil, the rule only applies to paths matching the pattern
+	Match     *regexp.Regexp
+	Replace   string
+	Extension string
+	Separator string
+}
+
+func (rule *RewriteRule) Apply(file string) string {
+	if rule.Match != nil && !rule.Match.MatchString(file) {
+		return file
+	}
+
+	switch rule.Type {
+	case RewriteRegex:
+		if rule.Match == nil {
+			return file
+		}
+		return rule.Match.ReplaceAllString(file, rule.Replace)
+	case RewriteStripExtension:
+		base := path.Base(file)
+		ext := rule.Extension
+		if ext == "" {
+			ext = path.Ext(base)
+		}
+
+		if ext == "" || base == ext || !strings.HasSuffix(base, ext) {
+			return file
+		}
+		return strings.TrimSuffix(file, ext)
+	case RewriteFlatten:
+		if rule.Separator == "" {
+			return path.Base(file)
+		}
+		return strings.ReplaceAll(file, "/", rule.Separator)
+	default:
+		return file
+	}
+}
+
+/*
+Applies the rules in order, each rule operating on the result of the previous one
+*/
+func RewritePath(file string, rules []RewriteRule) string {
+	for _, rule := range rules {
+		file = rule.Apply(file)
+	}
+
+	return file
+}
+
+/*
+Rewrites the paths of the values.
+Returns an error if several paths are rewritten to the same path.
+*/
+func RewriteValues(values map[string]string, rules []RewriteRule) (map[string]string, error) {
+	if len(rules) == 0 {
+		return values, nil
+	}
+
+	rewritten := map[string]string{}
+	origins := map[string]string{}
+	for file, val := range values {
+		target := RewritePath(file, rules)
+		if origin, ok := origins[target]; ok {
+			return rewritten, errors.New(fmt.Sprintf("Keys %s and %s are both rewritten to %s", origin, file, target))
+		}
+
+		origins[target] = file
+		rewritten[target] = val
+	}
+
+	return rewritten, nil
+}
@@ -0,0 +1,109 @@
+package filesystem
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestRewritePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		rules    []RewriteRule
+		expected string
+	}{
+		{"no rules", "dir/app.conf", []RewriteRule{}, "dir/app.conf"},
+		{
+			"regex with capture groups",
+			"env/prod/app.conf",
+			[]RewriteRule{{Type: RewriteRegex, Match: regexp.MustCompile(`^env/([^/]+)/(.*)$`), Replace: "$2.$1"}},
+			"app.conf.prod",
+		},
+		{
+			"regex without a pattern",
+			"app.conf",
+			[]RewriteRule{{Type: RewriteRegex, Replace: "other"}},
+			"app.conf",
+		},
+		{"strip any extension", "dir/app.conf.j2", []RewriteRule{{Type: RewriteStripExtension}}, "dir/app.conf"},
+		{"strip a given extension", "dir/app.conf.j2", []RewriteRule{{Type: RewriteStripExtension, Extension: ".j2"}}, "dir/app.conf"},
+		{"given extension not matching", "dir/app.conf", []RewriteRule{{Type: RewriteStripExtension, Extension: ".j2"}}, "dir/app.conf"},
+		{"file name that is only the extension", "dir/.j2", []RewriteRule{{Type: RewriteStripExtension, Extension: ".j2"}}, "dir/.j2"},
+		{"file without an extension", "dir.d/app", []RewriteRule{{Type: RewriteStripExtension}}, "dir.d/app"},
+		{"flatten to the file name", "a/b/app.conf", []RewriteRule{{Type: RewriteFlatten}}, "app.conf"},
+		{"flatten with a separator", "a/b/app.conf", []RewriteRule{{Type: RewriteFlatten, Separator: "_"}}, "a_b_app.conf"},
+		{
+			"rule not applying to paths that do not match",
+			"other/app.conf",
+			[]RewriteRule{{Type: RewriteFlatten, Match: regexp.MustCompile(`^a/`)}},
+			"other/app.conf",
+		},
+		{
+			"rules applied in order",
+			"a/b/app.conf.j2",
+			[]RewriteRule{{Type: RewriteStripExtension}, {Type: RewriteFlatten, Separator: "-"}},
+			"a-b-app.conf",
+		},
+		{"unknown type", "a/app.conf", []RewriteRule{{Type: "unknown"}}, "a/app.conf"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := RewritePath(test.file, test.rules)
+			if result != test.expected {
+				t.Errorf("RewritePath(%q) = %q, expected %q", test.file, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestRewriteValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]string
+		rules    []RewriteRule
+		expected map[string]string
+		valid    bool
+	}{
+		{
+			name:     "no rules",
+			values:   map[string]string{"a/app.conf": "a"},
+			rules:    []RewriteRule{},
+			expected: map[string]string{"a/app.conf": "a"},
+			valid:    true,
+		},
+		{
+			name:     "rewritten paths",
+			values:   map[string]string{"a/app.conf": "a", "b/db.conf": "b"},
+			rules:    []RewriteRule{{Type: RewriteFlatten}},
+			expected: map[string]string{"app.conf": "a", "db.conf": "b"},
+			valid:    true,
+		},
+		{
+			name:   "paths rewritten to the same path",
+			values: map[string]string{"a/app.conf": "a", "b/app.conf": "b"},
+			rules:  []RewriteRule{{Type: RewriteFlatten}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := RewriteValues(test.values, test.rules)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("RewriteValues() = %v, expected an error", result)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("RewriteValues() = %v, expected %v", result, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+/*
+Prefix of the temporary directories holding the previous versions of the files while a diff is applied.
+They are created at the top of the directory, so that only the directory itself needs to be writable, and the name is reserved: keys under it are rejected and directory scans skip it.
+*/
+const RollbackDirPrefix = ".rollback-"
+
+/*
+Returns true if the relative path, with forward slashes, is a rollback directory or is under one
+*/
+func IsRollbackPath(rel string) bool {
+	return strings.HasPrefix(strings.SplitN(rel, "/", 2)[0], RollbackDirPrefix)
+}
+
+/*
+Removes the rollback directories left over by a process that was interrupted while applying a diff to the directory
+*/
+func CleanupRollbackDirectories(root string) error {
+	stale, err := filepath.Glob(filepath.Join(root, RollbackDirPrefix+"*"))
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range stale {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error removing stale rollback directory %s: %s", dir, err.Error()))
+		}
+	}
+
+	return nil
+}
+
+/*
+Moves a file back in place, copying it if it was backed up on another filesystem
+*/
+func moveFile(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	info, statErr := os.Lstat(src)
+	if statErr != nil {
+		return err
+	}
+
+	tmpPath := dst + ".tmp"
+	os.Remove(tmpPath)
+	copyErr := copyFile(src, tmpPath, info.Mode().Perm())
+	if copyErr != nil {
+		os.Remove(tmpPath)
+		return copyErr
+	}
+
+	return os.Rename(tmpPath, dst)
+}
+
+type fileBackup struct {
+	Path   string
+	Backup string
+}
+
+/*
+Backup of the files a diff is about to touch, allowing the directory to be restored to its previous state if the diff fails midway.
+Backups are hard links (or copies if hard links are not supported) in a temporary directory at the top of the directory, which is cheap given that files are replaced by rename rather than modified in place.
+*/
+type directorySnapshot struct {
+	DirPermission os.FileMode
+	BackupDir     string
+	Files         []fileBackup
+	CreatedDirs   []string
+}
+
+func snapshotFiles(root string, files []string, dirPermission os.FileMode) (*directorySnapshot, error) {
+	backupDir, err := os.MkdirTemp(root, RollbackDirPrefix)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error creating rollback directory: %s", err.Error()))
+	}
+
+	snapshot := directorySnapshot{
+		DirPermission: dirPermission,
+		BackupDir:     backupDir,
+		Files:         []fileBackup{},
+		CreatedDirs:   []string{},
+	}
+
+	for idx, file := range files {
+		info, statErr := os.Lstat(file)
+		if statErr != nil {
+			if !errors.Is(statErr, os.ErrNotExist) && !errors.Is(statErr, syscall.ENOTDIR) {
+				os.RemoveAll(backupDir)
+				return nil, statErr
+			}
+
+			snapshot.Files = append(snapshot.Files, fileBackup{Path: file, Backup: ""})
+			continue
+		}
+
+		backup := filepath.Join(backupDir, strconv.Itoa(idx))
+		linkErr := os.Link(file, backup)
+		if linkErr != nil {
+			linkErr = copyFile(file, backup, info.Mode().Perm())
+			if linkErr != nil {
+				os.RemoveAll(backupDir)
+				return nil, errors.New(fmt.Sprintf("Error backing up file %s: %s", file, linkErr.Error()))
+			}
+		}
+
+		snapshot.Files = append(snapshot.Files, fileBackup{Path: file, Backup: backup})
+	}
+
+	return &snapshot, nil
+}
+
+/*
+Records the topmost missing ancestor of a directory that is about to be created so that it can be removed on rollback
+*/
+func (s *directorySnapshot) trackDir(dir string) {
+	if s == nil {
+		return
+	}
+
+	missing := ""
+	for current := dir; ; current = filepath.Dir(current) {
+		_, err := os.Lstat(current)
+		if err == nil || !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+
+		missing = current
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+
+	if missing != "" {
+		s.CreatedDirs = append(s.CreatedDirs, missing)
+	}
+}
+
+func (s *directorySnapshot) restore() error {
+	for _, file := range s.Files {
+		if file.Backup == "" {
+			err := os.Remove(file.Path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR) {
+				return err
+			}
+			continue
+		}
+
+		err := os.MkdirAll(filepath.Dir(file.Path), s.DirPermission)
+		if err != nil {
+			return err
+		}
+
+		err = moveFile(file.Backup, file.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	for idx := len(s.CreatedDirs) - 1; idx >= 0; idx-- {
+		err := os.RemoveAll(s.CreatedDirs[idx])
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(s.BackupDir)
+}
+
+func (s *directorySnapshot) discard() error {
+	if s == nil {
+		return nil
+	}
+
+	return os.RemoveAll(s.BackupDir)
+}
@@ -0,0 +1,149 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+func isWithin(root string, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+/*
+Returns an error if any existing component of the relative path under the root is a symlink resolving outside of the root
+*/
+func checkSymlinks(root string, rel string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	current := root
+	for _, component := range strings.Split(rel, "/") {
+		current = filepath.Join(current, component)
+		info, statErr := os.Lstat(current)
+		if statErr != nil {
+			if errors.Is(statErr, os.ErrNotExist) {
+				return nil
+			}
+			return statErr
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		target, evalErr := filepath.EvalSymlinks(current)
+		if evalErr != nil && !errors.Is(evalErr, os.ErrNotExist) {
+			return evalErr
+		}
+
+		if evalErr != nil || !isWithin(realRoot, target) {
+			return errors.New(fmt.Sprintf("Key %s goes through symlink %s which points outside of the directory", rel, current))
+		}
+	}
+
+	return nil
+}
+
+/*
+Normalizes a key into a clean relative path with forward slashes and returns an error if it is not safe to write under the root.
+Keys are rejected if they contain a NUL byte, if they are absolute, if they resolve outside of the root, if they are under a rollback directory or if they go through a symlink that points outside of the root.
+*/
+func SanitizeKey(root string, key string) (string, error) {
+	if strings.ContainsRune(key, 0) {
+		return "", errors.New(fmt.Sprintf("Key %q contains a NUL byte", key))
+	}
+
+	cleaned := path.Clean(key)
+	if path.IsAbs(cleaned) || filepath.IsAbs(filepath.FromSlash(cleaned)) {
+		return "", errors.New(fmt.Sprintf("Key %s is an absolute path", key))
+	}
+
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", errors.New(fmt.Sprintf("Key %s resolves outside of the directory", key))
+	}
+
+	if IsRollbackPath(cleaned) {
+		return "", errors.New(fmt.Sprintf("Key %s is under the name reserved for rollback directories", key))
+	}
+
+	symErr := checkSymlinks(root, cleaned)
+	if symErr != nil {
+		return "", symErr
+	}
+
+	return cleaned, nil
+}
+
+/*
+Sanitizes the keys of a key/value map.
+Returns the values with their normalized keys as well as the errors of the keys that were rejected.
+*/
+func SanitizeValues(root string, values map[string]string) (map[string]string, []error) {
+	sanitized := map[string]string{}
+	errs := []error{}
+
+	for key, val := range values {
+		cleaned, err := SanitizeKey(root, key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sanitized[cleaned] = val
+	}
+
+	return sanitized, errs
+}
+
+func checkDiffKeys(root string, diff client.KeyDiff) error {
+	check := func(key string) error {
+		cleaned, err := SanitizeKey(root, key)
+		if err != nil {
+			return err
+		}
+
+		if cleaned != key {
+			return errors.New(fmt.Sprintf("Key %s is not normalized", key))
+		}
+
+		return nil
+	}
+
+	for _, key := range diff.Deletions {
+		err := check(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	for key, _ := range diff.Inserts {
+		err := check(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	for key, _ := range diff.Updates {
+		err := check(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSanitizeKey(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	err := os.Mkdir(filepath.Join(root, "inside"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink(outside, filepath.Join(root, "escape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink(filepath.Join(root, "inside"), filepath.Join(root, "internal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+		valid    bool
+	}{
+		{"plain file", "app.conf", "app.conf", true},
+		{"nested file", "dir/app.conf", "dir/app.conf", true},
+		{"redundant components", "./dir//sub/../app.conf", "dir/app.conf", true},
+		{"parent that stays inside", "dir/../app.conf", "app.conf", true},
+		{"symlink inside the root", "internal/app.conf", "internal/app.conf", true},
+		{"missing parent directories", "missing/dir/app.conf", "missing/dir/app.conf", true},
+		{"absolute path", "/etc/passwd", "", false},
+		{"parent directory", "../app.conf", "", false},
+		{"parent directory after cleaning", "dir/../../app.conf", "", false},
+		{"parent directory only", "..", "", false},
+		{"root itself", ".", "", false},
+		{"empty key", "", "", false},
+		{"nul byte", "app\x00.conf", "", false},
+		{"symlink outside the root", "escape/app.conf", "", false},
+		{"rollback directory", ".rollback-123/app.conf", "", false},
+		{"rollback name below the top", "dir/.rollback-123", "dir/.rollback-123", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sanitized, err := SanitizeKey(root, test.key)
+			if test.valid && err != nil {
+				t.Fatalf("SanitizeKey(%q) returned unexpected error: %s", test.key, err.Error())
+			}
+
+			if !test.valid && err == nil {
+				t.Fatalf("SanitizeKey(%q) = %q, expected an error", test.key, sanitized)
+			}
+
+			if sanitized != test.expected {
+				t.Errorf("SanitizeKey(%q) = %q, expected %q", test.key, sanitized, test.expected)
+			}
+		})
+	}
+}
+
+func TestSanitizeValues(t *testing.T) {
+	root := t.TempDir()
+
+	values, errs := SanitizeValues(root, map[string]string{
+		"./a":  "a",
+		"b/c":  "c",
+		"../d": "d",
+	})
+
+	expected := map[string]string{"a": "a", "b/c": "c"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("SanitizeValues() = %v, expected %v", values, expected)
+	}
+
+	if len(errs) != 1 {
+		t.Errorf("Expected 1 error, got %v", errs)
+	}
+}
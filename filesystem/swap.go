@@ -0,0 +1,393 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+const (
+	SwapDataLink          = "..data"
+	swapReservedPrefix    = ".."
+	swapVersionTimeFormat = "2006_01_02_15_04_05.000000000"
+)
+
+/*
+Returns the versioned directory the data symlink of a swap-managed directory currently points to.
+An empty string is returned if the directory has not been materialized in swap mode yet.
+*/
+func GetSwapDataDir(path string) (string, error) {
+	target, err := os.Readlink(filepath.Join(path, SwapDataLink))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return filepath.Join(path, target), nil
+}
+
+func copyFile(src string, dst string, permission os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, permission)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	err = out.Sync()
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = copyOwner(info, dst)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+/*
+Gives the copy of a file the ownership of the original, which may have been set by the permission rules or an envelope
+*/
+func copyOwner(info os.FileInfo, dst string) error {
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return nil
+	}
+
+	return os.Lchown(dst, uid, gid)
+}
+
+func copyTree(src string, dst string, filter func(rel string) bool) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, relErr := filepath.Rel(src, path)
+		if relErr != nil {
+			return relErr
+		}
+		target := filepath.Join(dst, rel)
+
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			return infoErr
+		}
+
+		switch {
+		case entry.IsDir():
+			if rel == "." {
+				return nil
+			}
+			mkdirErr := os.Mkdir(target, info.Mode().Perm())
+			if mkdirErr != nil {
+				return mkdirErr
+			}
+			return copyOwner(info, target)
+		case info.Mode()&os.ModeSymlink != 0:
+			if !filter(filepath.ToSlash(rel)) {
+				return nil
+			}
+			link, linkErr := os.Readlink(path)
+			if linkErr != nil {
+				return linkErr
+			}
+			symlinkErr := os.Symlink(link, target)
+			if symlinkErr != nil {
+				return symlinkErr
+			}
+			return copyOwner(info, target)
+		default:
+			if !filter(filepath.ToSlash(rel)) {
+				return nil
+			}
+			return copyFile(path, target, info.Mode().Perm())
+		}
+	})
+}
+
+func swapSymlink(linkPath string, target string) error {
+	tmpPath := linkPath + ".tmp"
+	os.Remove(tmpPath)
+
+	err := os.Symlink(target, tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, linkPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+func isDataLink(linkPath string) bool {
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(target, SwapDataLink+string(filepath.Separator))
+}
+
+/*
+Returns an error if a top-level entry of the data directory would replace an entry of the directory that is not a symlink managed by the updater
+*/
+func checkTopLevelConflicts(path string, dataDir string) error {
+	dataEntries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range dataEntries {
+		linkPath := filepath.Join(path, entry.Name())
+		_, statErr := os.Lstat(linkPath)
+		if statErr != nil {
+			if errors.Is(statErr, os.ErrNotExist) {
+				continue
+			}
+			return statErr
+		}
+
+		if !isDataLink(linkPath) {
+			return errors.New(fmt.Sprintf("Refusing to overwrite file %s which is not managed by the updater", entry.Name()))
+		}
+	}
+
+	return nil
+}
+
+/*
+Returns an error if the directory has top-level entries other than symlinks that swap mode would replace or remove, such as the files of a directory that was not synchronized in swap mode before.
+Such entries are never deleted: they should be moved out of the directory before swap mode is enabled.
+*/
+func CheckSwapDirectory(path string, exclude []string) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	unexpected := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, swapReservedPrefix) || IsRollbackPath(name) || IsExcluded(name, exclude) || entry.Type()&os.ModeSymlink != 0 {
+			continue
+		}
+		unexpected = append(unexpected, name)
+	}
+
+	if len(unexpected) > 0 {
+		sort.Strings(unexpected)
+		return errors.New(fmt.Sprintf("Directory %s has entries that are not symlinks managed by swap mode (%s). Move them out of the directory before enabling swap mode or exclude them", path, strings.Join(unexpected, ", ")))
+	}
+
+	return nil
+}
+
+func syncTopLevelLinks(path string, dataDir string, managedOnly bool, exclude []string) error {
+	dataEntries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return err
+	}
+
+	expected := map[string]bool{}
+	for _, entry := range dataEntries {
+		expected[entry.Name()] = true
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, swapReservedPrefix) || expected[name] || IsExcluded(name, exclude) {
+			continue
+		}
+
+		linkPath := filepath.Join(path, name)
+		if managedOnly && !isDataLink(linkPath) {
+			continue
+		}
+
+		if entry.Type()&os.ModeSymlink == 0 {
+			return errors.New(fmt.Sprintf("Refusing to remove %s which is not a symlink", name))
+		}
+
+		err := os.Remove(linkPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, _ := range expected {
+		linkPath := filepath.Join(path, name)
+		linkTarget := filepath.Join(SwapDataLink, name)
+
+		info, statErr := os.Lstat(linkPath)
+		if statErr == nil {
+			if info.Mode()&os.ModeSymlink != 0 {
+				current, _ := os.Readlink(linkPath)
+				if current == linkTarget {
+					continue
+				}
+			} else {
+				return errors.New(fmt.Sprintf("Refusing to replace %s which is not a symlink", name))
+			}
+		} else if !errors.Is(statErr, os.ErrNotExist) {
+			return statErr
+		}
+
+		err := swapSymlink(linkPath, linkTarget)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func cleanupSwapVersions(path string, currentDir string, keptVersions uint64) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	versions := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !strings.HasPrefix(name, swapReservedPrefix) || name == filepath.Base(currentDir) {
+			continue
+		}
+		versions = append(versions, name)
+	}
+
+	//Version names are timestamps and thus sort chronologically
+	sort.Strings(versions)
+
+	kept := uint64(0)
+	if keptVersions > 1 {
+		kept = keptVersions - 1
+	}
+
+	for len(versions) > int(kept) {
+		err := os.RemoveAll(filepath.Join(path, versions[0]))
+		if err != nil {
+			return err
+		}
+		versions = versions[1:]
+	}
+
+	return nil
+}
+
+/*
+Applies the diff to a new versioned copy of the directory's content and atomically flips the data symlink to it, so that the whole diff becomes visible at once.
+The top-level entries of the directory are symlinks that resolve through the data symlink.
+If the options have a manifest, only the files it lists are carried over to the new version and only top-level entries that are symlinks to the data directory are replaced or removed.
+Older versions are garbage-collected, keeping at most the given number of versions, including the current one.
+*/
+func ApplyDiffWithSwap(path string, diff client.KeyDiff, opts ApplyOptions, keptVersions uint64) error {
+	currentDir, err := GetSwapDataDir(path)
+	if err != nil {
+		return err
+	}
+
+	newDir := filepath.Join(path, swapReservedPrefix+time.Now().UTC().Format(swapVersionTimeFormat))
+	err = os.Mkdir(newDir, opts.DirectoriesPermission)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error creating versioned directory: %s", err.Error()))
+	}
+
+	var previousManifest map[string]bool
+	if opts.Manifest != nil {
+		previousManifest = opts.Manifest.Copy()
+	}
+
+	stageErr := func() error {
+		if currentDir != "" {
+			err := copyTree(currentDir, newDir, func(rel string) bool {
+				return (opts.Manifest == nil || opts.Manifest.Contains(rel)) && !IsExcluded(rel, opts.Exclude)
+			})
+			if err != nil {
+				return errors.New(fmt.Sprintf("Error copying the current version in the versioned directory: %s", err.Error()))
+			}
+		}
+
+		//The versioned directory is removed if the diff fails, so the files it touches are not backed up
+		applyErr := applyDiff(newDir, diff, opts, false)
+		if applyErr != nil {
+			return applyErr
+		}
+
+		_, pruneErr := RemoveEmptyDirectories(newDir, opts.Exclude)
+		if pruneErr != nil {
+			return pruneErr
+		}
+
+		if opts.Manifest != nil {
+			return checkTopLevelConflicts(path, newDir)
+		}
+
+		return nil
+	}()
+	if stageErr != nil {
+		os.RemoveAll(newDir)
+		if opts.Manifest != nil {
+			opts.Manifest.Files = previousManifest
+			opts.Manifest.Save()
+		}
+		return stageErr
+	}
+
+	err = swapSymlink(filepath.Join(path, SwapDataLink), filepath.Base(newDir))
+	if err != nil {
+		os.RemoveAll(newDir)
+		return errors.New(fmt.Sprintf("Error swapping the data symlink: %s", err.Error()))
+	}
+
+	err = syncTopLevelLinks(path, newDir, opts.Manifest != nil, opts.Exclude)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error updating the top-level symlinks: %s", err.Error()))
+	}
+
+	return cleanupSwapVersions(path, newDir, keptVersions)
+}
@@ -0,0 +1,155 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+func getSwapOptions() ApplyOptions {
+	return ApplyOptions{FilesPermission: 0600, DirectoriesPermission: 0700}
+}
+
+func getSwapVersions(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), swapReservedPrefix) {
+			versions = append(versions, entry.Name())
+		}
+	}
+
+	return versions
+}
+
+func TestApplyDiffWithSwapGarbageCollectsVersions(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, content := range []string{"1", "2", "3", "4"} {
+		diff := client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{"a": content}, Deletions: []string{}}
+		err := ApplyDiffWithSwap(dir, diff, getSwapOptions(), 2)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	versions := getSwapVersions(t, dir)
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions to be kept, got %v", versions)
+	}
+
+	current, err := GetSwapDataDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(current) != versions[1] {
+		t.Errorf("Expected the data symlink to point to the latest version %s, got %s", versions[1], current)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "4" {
+		t.Errorf("Expected the latest content, got %q", string(content))
+	}
+}
+
+func TestApplyDiffWithSwapSyncsTopLevelLinks(t *testing.T) {
+	dir := t.TempDir()
+
+	diff := client.KeyDiff{Inserts: map[string]string{"a": "a", "sub/b": "b"}, Updates: map[string]string{}, Deletions: []string{}}
+	err := ApplyDiffWithSwap(dir, diff, getSwapOptions(), 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	for _, name := range []string{"a", "sub"} {
+		target, linkErr := os.Readlink(filepath.Join(dir, name))
+		if linkErr != nil {
+			t.Fatalf("Expected %s to be a symlink: %s", name, linkErr.Error())
+		}
+		if target != filepath.Join(SwapDataLink, name) {
+			t.Errorf("Expected %s to point to %s, got %s", name, filepath.Join(SwapDataLink, name), target)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "sub", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "b" {
+		t.Errorf("Expected the content of sub/b through the symlinks, got %q", string(content))
+	}
+
+	diff = client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{}, Deletions: []string{"a"}}
+	err = ApplyDiffWithSwap(dir, diff, getSwapOptions(), 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	_, err = os.Lstat(filepath.Join(dir, "a"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected the symlink of the deleted file to be removed, got %v", err)
+	}
+}
+
+func TestApplyDiffWithSwapRefusesNonSymlinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest bool
+		diff     client.KeyDiff
+	}{
+		{
+			name: "replacing a regular file",
+			diff: client.KeyDiff{Inserts: map[string]string{"a": "new"}, Updates: map[string]string{}, Deletions: []string{}},
+		},
+		{
+			name: "removing a regular file",
+			diff: client.KeyDiff{Inserts: map[string]string{"b": "new"}, Updates: map[string]string{}, Deletions: []string{}},
+		},
+		{
+			name:     "replacing an unmanaged regular file",
+			manifest: true,
+			diff:     client.KeyDiff{Inserts: map[string]string{"a": "new"}, Updates: map[string]string{}, Deletions: []string{}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, "a"), []byte("original"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts := getSwapOptions()
+			if test.manifest {
+				opts.Manifest, err = LoadManifest(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = ApplyDiffWithSwap(dir, test.diff, opts, 2)
+			if err == nil {
+				t.Errorf("Expected an error")
+			}
+
+			content, err := os.ReadFile(filepath.Join(dir, "a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != "original" {
+				t.Errorf("Expected the regular file to be left untouched, got %q", string(content))
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+/*
+Renames the first path to the second and the second to the first, one after the other
+*/
+func renamePaths(first string, second string) error {
+	tmp := first + ".exchange"
+	err := os.Rename(second, tmp)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(first, second)
+	if err != nil {
+		os.Rename(tmp, second)
+		return err
+	}
+
+	return os.Rename(tmp, first)
+}
+
+/*
+Recreates the directory tree of the source in the destination with hard links to its files (or copies if hard links are not supported).
+As files are always replaced by rename rather than modified in place, the links can safely be shared between both trees.
+*/
+func linkTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, relErr := filepath.Rel(src, path)
+		if relErr != nil {
+			return relErr
+		}
+		target := filepath.Join(dst, rel)
+
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			return infoErr
+		}
+
+		if entry.IsDir() {
+			if rel == "." {
+				return nil
+			}
+			return os.Mkdir(target, info.Mode().Perm())
+		}
+
+		linkErr := os.Link(path, target)
+		if linkErr != nil && info.Mode().IsRegular() {
+			return copyFile(path, target, info.Mode().Perm())
+		}
+
+		return linkErr
+	})
+}
+
+/*
+Separates the operations of a diff that fall under the atomic directories from the rest of the diff.
+Returns the operations of each atomic directory with keys relative to it and the remaining operations.
+*/
+func splitTreeDiffs(diff client.KeyDiff, atomicDirs []string) (map[string]client.KeyDiff, client.KeyDiff) {
+	dirs := append([]string{}, atomicDirs...)
+	//Sorting places a directory before the directories it contains, so that nested atomic directories are replaced along with their parent
+	sort.Strings(dirs)
+
+	getDir := func(key string) (string, string, bool) {
+		for _, dir := range dirs {
+			if strings.HasPrefix(key, dir+"/") {
+				return dir, strings.TrimPrefix(key, dir+"/"), true
+			}
+		}
+		return "", key, false
+	}
+
+	trees := map[string]client.KeyDiff{}
+	getTree := func(dir string) client.KeyDiff {
+		tree, ok := trees[dir]
+		if !ok {
+			tree = client.KeyDiff{
+				Inserts:   map[string]string{},
+				Updates:   map[string]string{},
+				Deletions: []string{},
+			}
+			trees[dir] = tree
+		}
+		return tree
+	}
+
+	remaining := client.KeyDiff{
+		Inserts:   map[string]string{},
+		Updates:   map[string]string{},
+		Deletions: []string{},
+	}
+
+	for _, key := range diff.Deletions {
+		if dir, rel, ok := getDir(key); ok {
+			tree := getTree(dir)
+			tree.Deletions = append(tree.Deletions, rel)
+			trees[dir] = tree
+			continue
+		}
+		remaining.Deletions = append(remaining.Deletions, key)
+	}
+
+	for key, val := range diff.Inserts {
+		if dir, rel, ok := getDir(key); ok {
+			getTree(dir).Inserts[rel] = val
+			continue
+		}
+		remaining.Inserts[key] = val
+	}
+
+	for key, val := range diff.Updates {
+		if dir, rel, ok := getDir(key); ok {
+			getTree(dir).Updates[rel] = val
+			continue
+		}
+		remaining.Updates[key] = val
+	}
+
+	return trees, remaining
+}
+
+/*
+Applies the operations of a diff under a directory by staging a copy of the directory with the changes and swapping it with the directory in a single rename.
+Readers of the directory thus see either its previous content or its new content, never a mix of both.
+*/
+func replaceTree(root string, dir string, diff client.KeyDiff, opts ApplyOptions, snapshot *directorySnapshot) error {
+	target := filepath.Join(root, filepath.FromSlash(dir))
+	parent := filepath.Dir(target)
+
+	snapshot.trackDir(target)
+	mkdirErr := os.MkdirAll(parent, opts.DirectoriesPermission)
+	if mkdirErr != nil {
+		return mkdirErr
+	}
+
+	info, statErr := os.Lstat(target)
+	exists := statErr == nil
+	if statErr != nil && !errors.Is(statErr, os.ErrNotExist) {
+		return statErr
+	}
+
+	if exists && !info.IsDir() {
+		return errors.New(fmt.Sprintf("Cannot replace %s with a directory as it is not one", target))
+	}
+
+	staging, stagingErr := os.MkdirTemp(parent, "."+filepath.Base(target)+".staging-")
+	if stagingErr != nil {
+		return errors.New(fmt.Sprintf("Error creating staging directory for %s: %s", target, stagingErr.Error()))
+	}
+	//Once swapped, the staging directory holds the previous tree, so it is removed either way
+	defer os.RemoveAll(staging)
+
+	permission := opts.DirectoriesPermission
+	if exists {
+		permission = info.Mode().Perm()
+		linkErr := linkTree(target, staging)
+		if linkErr != nil {
+			return linkErr
+		}
+	}
+
+	chmodErr := os.Chmod(staging, permission)
+	if chmodErr != nil {
+		return chmodErr
+	}
+
+	for _, file := range diff.Deletions {
+		err := os.Remove(filepath.Join(staging, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+	}
+
+	upsertFile := func(file string, content string) error {
+		fPath := filepath.Join(staging, filepath.FromSlash(file))
+		err := os.MkdirAll(filepath.Dir(fPath), opts.DirectoriesPermission)
+		if err != nil {
+			return err
+		}
+
+		decoded, attrs, err := opts.ResolveValue(dir+"/"+file, content)
+		if err != nil {
+			return err
+		}
+
+		return WriteFileAtomicallyWithAttributes(fPath, []byte(decoded), attrs)
+	}
+
+	for file, content := range diff.Inserts {
+		err := upsertFile(file, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	for file, content := range diff.Updates {
+		err := upsertFile(file, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, pruneErr := RemoveEmptyDirectories(staging, []string{})
+	if pruneErr != nil {
+		return pruneErr
+	}
+
+	empty, emptyErr := isDirEmpty(staging)
+	if emptyErr != nil {
+		return emptyErr
+	}
+
+	if !exists {
+		if empty {
+			return nil
+		}
+		return os.Rename(staging, target)
+	}
+
+	swapErr := exchangePaths(staging, target)
+	if swapErr != nil {
+		return errors.New(fmt.Sprintf("Error swapping staging directory with %s: %s", target, swapErr.Error()))
+	}
+
+	if empty {
+		return pruneEmptyParents(root, target)
+	}
+
+	return nil
+}
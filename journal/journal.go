@@ -0,0 +1,123 @@
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/filesystem"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+/*
+Record of a change being applied to the filesystem.
+It is persisted before the change is applied and updated once its hooks completed so that the hooks of an interrupted change can be completed on restart.
+Only the files of the change are recorded, not their content, so that the journal never holds decrypted values.
+*/
+type Entry struct {
+	Revision       int64    `json:"revision"`
+	Inserts        []string `json:"inserts"`
+	Updates        []string `json:"updates"`
+	Deletions      []string `json:"deletions"`
+	HooksCompleted bool     `json:"hooks_completed"`
+}
+
+func getSortedKeys(values map[string]string) []string {
+	keys := []string{}
+	for key, _ := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+/*
+Returns the entry of a change, recording the files it inserts, updates and deletes
+*/
+func NewEntry(revision int64, diff client.KeyDiff) Entry {
+	deletions := append([]string{}, diff.Deletions...)
+	sort.Strings(deletions)
+
+	return Entry{
+		Revision:  revision,
+		Inserts:   getSortedKeys(diff.Inserts),
+		Updates:   getSortedKeys(diff.Updates),
+		Deletions: deletions,
+	}
+}
+
+/*
+Returns true if the change was interrupted before its hooks completed
+*/
+func (e *Entry) IsPending() bool {
+	return !e.HooksCompleted
+}
+
+/*
+Write-ahead journal of the changes applied to the filesystem.
+A journal with an empty path is disabled and all its operations are no-ops.
+*/
+type Journal struct {
+	Path string
+}
+
+func (j *Journal) IsEnabled() bool {
+	return j.Path != ""
+}
+
+/*
+Returns the last entry recorded in the journal or nil if there is none
+*/
+func (j *Journal) Read() (*Entry, error) {
+	if !j.IsEnabled() {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(j.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, errors.New(fmt.Sprintf("Error reading journal: %s", err.Error()))
+	}
+
+	var entry Entry
+	err = json.Unmarshal(content, &entry)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error parsing journal: %s", err.Error()))
+	}
+
+	return &entry, nil
+}
+
+/*
+Durably records the entry in the journal, replacing the previous one
+*/
+func (j *Journal) Write(entry Entry) error {
+	if !j.IsEnabled() {
+		return nil
+	}
+
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error serializing journal entry: %s", err.Error()))
+	}
+
+	mkdirErr := os.MkdirAll(filepath.Dir(j.Path), 0700)
+	if mkdirErr != nil {
+		return errors.New(fmt.Sprintf("Error creating journal directory: %s", mkdirErr.Error()))
+	}
+
+	err = filesystem.WriteFileAtomically(j.Path, content, 0600)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error writing journal: %s", err.Error()))
+	}
+
+	return nil
+}
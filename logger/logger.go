@@ -11,26 +11,28 @@ const (
 
 type Logger struct {
 	LogLevel int64
+	//Optional prefix added to all messages (ex: to tell apart the messages of different jobs)
+	Prefix string
 }
 
 func (logger Logger) Debugf(format string, args ...interface{}) {
 	if logger.LogLevel <= DEBUG {
-		log.Printf(format+"\n", args...)
+		log.Printf(logger.Prefix+format+"\n", args...)
 	}
 }
 
 func (logger Logger) Infof(format string, args ...interface{}) {
 	if logger.LogLevel <= INFO {
-		log.Printf(format+"\n", args...)
+		log.Printf(logger.Prefix+format+"\n", args...)
 	}
 }
 
 func (logger Logger) Warnf(format string, args ...interface{}) {
 	if logger.LogLevel <= WARN {
-		log.Printf(format+"\n", args...)
+		log.Printf(logger.Prefix+format+"\n", args...)
 	}
 }
 
 func (logger Logger) Errorf(format string, args ...interface{}) {
-	log.Printf(format+"\n", args...)
+	log.Printf(logger.Prefix+format+"\n", args...)
 }
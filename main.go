@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/config"
 	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
 )
 
 func getEnv(key string, fallback string) string {
@@ -16,45 +21,60 @@ func getEnv(key string, fallback string) string {
 	return fallback
 }
 
-func main() {
-	log := logger.Logger{LogLevel: logger.ERROR}
+/*
+Delays before restarting a failed job under the continue failure policy.
+The delay doubles with each consecutive failure and is reset once the job has run for longer than the maximum delay.
+*/
+const (
+	jobRestartMinDelay = time.Second
+	jobRestartMaxDelay = 5 * time.Minute
+)
 
-	conf, err := config.GetConfig(getEnv("CONFS_AUTO_UPDATER_CONFIG_FILE", "config.yml"))
-	if err != nil {
-		log.Errorf(err.Error())
-		os.Exit(1)
+type jobResult struct {
+	Job     config.ConfigJob
+	Error   error
+	Started time.Time
+}
+
+/*
+Returns the delay before restarting a job that failed, given the delay before its previous restart
+*/
+func getJobRestartDelay(previous time.Duration, ran time.Duration) time.Duration {
+	if previous == 0 || ran > jobRestartMaxDelay {
+		return jobRestartMinDelay
 	}
 
-	log.LogLevel = conf.GetLogLevel()
+	if previous*2 > jobRestartMaxDelay {
+		return jobRestartMaxDelay
+	}
 
+	return previous * 2
+}
+
+/*
+Runs a sync job until it stops, pushing its changes to its grpc notification endpoints.
+Returns the error that stopped the job, if any.
+*/
+func runJob(job config.ConfigJob, cli *client.EtcdClient, log logger.Logger) error {
 	var proceedCh chan struct{}
 	var notifCli *GrpcNotifClient
-	if len(conf.GrpcNotifications) > 0 {
+	if len(job.GrpcNotifications) > 0 {
 		proceedCh = make(chan struct{})
 		defer close(proceedCh)
 
-		notifCli, err = ConnectToNotifEndpoints(conf.GrpcNotifications)
+		var err error
+		notifCli, err = ConnectToNotifEndpoints(job.GrpcNotifications)
 		if err != nil {
-			log.Errorf(err.Error())
-			os.Exit(1)
+			return err
 		}
 	}
 
-	syncCancel, syncFeedback := SyncFilesystem(conf, proceedCh, log)
-
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
-	go func() {
-		sig := <-sigChan
-		log.Warnf("[main] Caught signal %s. Terminating.", sig.String())
-		syncCancel()
-	}()
+	syncCancel, syncFeedback := SyncFilesystem(job, cli, proceedCh, log)
 
 	for feedback := range syncFeedback {
 		if feedback.Error != nil {
 			syncCancel()
-			log.Errorf(feedback.Error.Error())
-			os.Exit(1)
+			return feedback.Error
 		}
 
 		log.Infof(
@@ -68,11 +88,113 @@ func main() {
 			sendErr := notifCli.Send(feedback.Diff)
 			if sendErr != nil {
 				syncCancel()
-				close(sigChan)
-				log.Errorf(sendErr.Error())
-				os.Exit(1)
+				return sendErr
 			}
 			proceedCh <- struct{}{}
 		}
 	}
+
+	return nil
+}
+
+func main() {
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	conf, err := config.GetConfig(getEnv("CONFS_AUTO_UPDATER_CONFIG_FILE", "config.yml"))
+	if err != nil {
+		log.Errorf(err.Error())
+		os.Exit(1)
+	}
+
+	log.LogLevel = conf.GetLogLevel()
+
+	//Cancelling the context of the shared connection stops all the jobs
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cli, cliErr := ConnectToEtcd(ctx, conf)
+	if cliErr != nil {
+		log.Errorf(cliErr.Error())
+		os.Exit(1)
+	}
+	defer cli.Client.Close()
+
+	results := make(chan jobResult)
+	jobLogs := map[string]logger.Logger{}
+	startJob := func(job config.ConfigJob, delay time.Duration) {
+		go func() {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				results <- jobResult{Job: job}
+				return
+			}
+
+			started := time.Now()
+			results <- jobResult{Job: job, Error: runJob(job, cli, jobLogs[job.Name]), Started: started}
+		}()
+	}
+
+	for _, job := range conf.Jobs {
+		jobLog := log
+		if len(conf.Jobs) > 1 {
+			jobLog.Prefix = fmt.Sprintf("[job %s] ", job.Name)
+		}
+		jobLogs[job.Name] = jobLog
+
+		startJob(job, 0)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+
+	var failure error
+	stopping := false
+	running := len(conf.Jobs)
+	restartDelays := map[string]time.Duration{}
+	for running > 0 {
+		select {
+		case sig := <-sigChan:
+			log.Warnf("[main] Caught signal %s. Terminating.", sig.String())
+			stopping = true
+			cancel()
+		case res := <-results:
+			if res.Error == nil {
+				running--
+				continue
+			}
+
+			log.Errorf("[main] Job %s failed: %s", res.Job.Name, res.Error.Error())
+			if stopping {
+				running--
+				failure = res.Error
+				continue
+			}
+
+			//The failed job is restarted after a delay while the other jobs keep running
+			if conf.JobFailurePolicy == "continue" {
+				delay := getJobRestartDelay(restartDelays[res.Job.Name], time.Since(res.Started))
+				restartDelays[res.Job.Name] = delay
+				log.Warnf("[main] Restarting job %s in %s", res.Job.Name, delay.String())
+				startJob(res.Job, delay)
+				continue
+			}
+
+			running--
+			failure = res.Error
+			if running == 0 {
+				continue
+			}
+
+			//The other jobs are stopped before exiting so that none of them is interrupted in the middle of a change
+			log.Warnf("[main] Stopping the %d other jobs", running)
+			stopping = true
+			cancel()
+		}
+	}
+
+	if failure != nil {
+		cli.Client.Close()
+		os.Exit(1)
+	}
 }
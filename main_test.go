@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetJobRestartDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous time.Duration
+		ran      time.Duration
+		expected time.Duration
+	}{
+		{
+			name:     "first failure",
+			previous: 0,
+			ran:      time.Second,
+			expected: jobRestartMinDelay,
+		},
+		{
+			name:     "consecutive failure",
+			previous: 4 * time.Second,
+			ran:      time.Second,
+			expected: 8 * time.Second,
+		},
+		{
+			name:     "capped delay",
+			previous: 4 * time.Minute,
+			ran:      time.Second,
+			expected: jobRestartMaxDelay,
+		},
+		{
+			name:     "failure after running for a while",
+			previous: jobRestartMaxDelay,
+			ran:      jobRestartMaxDelay + time.Second,
+			expected: jobRestartMinDelay,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delay := getJobRestartDelay(test.previous, test.ran)
+			if delay != test.expected {
+				t.Errorf("getJobRestartDelay() = %s, expected %s", delay, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package overrides
+
+import (
+	"strings"
+)
+
+const (
+	HostsDir  = "hosts"
+	GroupsDir = "groups"
+)
+
+func overlay(result map[string]string, keys map[string]string, dir string) {
+	for key, val := range keys {
+		if strings.HasPrefix(key, dir) && len(key) > len(dir) {
+			result[strings.TrimPrefix(key, dir)] = val
+		}
+	}
+}
+
+/*
+Resolves the host and group overrides of a key space.
+Keys under "hosts/<hostname>/" and "groups/<group>/" override the base keys with the same path relative to their directory.
+Groups are applied in order, each overriding the ones before it, and the host's keys are applied last.
+The keys under the "hosts/" and "groups/" directories are removed from the key space, including those of other hosts and groups.
+*/
+func ApplyOverrides(keys map[string]string, hostname string, groups []string) map[string]string {
+	result := map[string]string{}
+	for key, val := range keys {
+		if strings.HasPrefix(key, HostsDir+"/") || strings.HasPrefix(key, GroupsDir+"/") {
+			continue
+		}
+		result[key] = val
+	}
+
+	for _, group := range groups {
+		overlay(result, keys, GroupsDir+"/"+group+"/")
+	}
+	overlay(result, keys, HostsDir+"/"+hostname+"/")
+
+	return result
+}
+
+/*
+Returns the path a key ends up at once the overrides are resolved.
+Returns false if the key is removed, being under the directory of another host or of a group that does not apply.
+*/
+func GetOverriddenPath(key string, hostname string, groups []string) (string, bool) {
+	dirs := []string{HostsDir + "/" + hostname + "/"}
+	for _, group := range groups {
+		dirs = append(dirs, GroupsDir+"/"+group+"/")
+	}
+
+	for _, dir := range dirs {
+		if strings.HasPrefix(key, dir) && len(key) > len(dir) {
+			return strings.TrimPrefix(key, dir), true
+		}
+	}
+
+	if strings.HasPrefix(key, HostsDir+"/") || strings.HasPrefix(key, GroupsDir+"/") {
+		return "", false
+	}
+
+	return key, true
+}
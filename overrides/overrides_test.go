@@ -0,0 +1,87 @@
+package overrides
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	keys := map[string]string{
+		"app.conf":                "base",
+		"db.conf":                 "base",
+		"log.conf":                "base",
+		"groups/east/app.conf":    "east",
+		"groups/east/db.conf":     "east",
+		"groups/canary/db.conf":   "canary",
+		"groups/west/app.conf":    "west",
+		"hosts/host1/app.conf":    "host1",
+		"hosts/host1/extra.conf":  "host1",
+		"hosts/host2/app.conf":    "host2",
+		"hosts/host1/":            "directory marker",
+		"groups/east/nested/a.js": "east",
+	}
+
+	tests := []struct {
+		name     string
+		hostname string
+		groups   []string
+		expected map[string]string
+	}{
+		{
+			name:     "no overrides",
+			hostname: "host3",
+			groups:   []string{},
+			expected: map[string]string{"app.conf": "base", "db.conf": "base", "log.conf": "base"},
+		},
+		{
+			name:     "group overrides",
+			hostname: "host3",
+			groups:   []string{"east"},
+			expected: map[string]string{"app.conf": "east", "db.conf": "east", "log.conf": "base", "nested/a.js": "east"},
+		},
+		{
+			name:     "later groups override earlier ones",
+			hostname: "host3",
+			groups:   []string{"east", "canary"},
+			expected: map[string]string{"app.conf": "east", "db.conf": "canary", "log.conf": "base", "nested/a.js": "east"},
+		},
+		{
+			name:     "host overrides the groups",
+			hostname: "host1",
+			groups:   []string{"east", "canary"},
+			expected: map[string]string{"app.conf": "host1", "db.conf": "canary", "log.conf": "base", "extra.conf": "host1", "nested/a.js": "east"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := ApplyOverrides(keys, test.hostname, test.groups)
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("ApplyOverrides() = %v, expected %v", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetOverriddenPath(t *testing.T) {
+	tests := []struct {
+		key      string
+		path     string
+		included bool
+	}{
+		{"app.conf", "app.conf", true},
+		{"hosts/host1/app.conf", "app.conf", true},
+		{"groups/east/dir/app.conf", "dir/app.conf", true},
+		{"hosts/host2/app.conf", "", false},
+		{"groups/west/app.conf", "", false},
+		{"hosts/host1/", "", false},
+		{"hostsfile.conf", "hostsfile.conf", true},
+	}
+
+	for _, test := range tests {
+		path, included := GetOverriddenPath(test.key, "host1", []string{"east"})
+		if path != test.path || included != test.included {
+			t.Errorf("GetOverriddenPath(%q) = %q, %t, expected %q, %t", test.key, path, included, test.path, test.included)
+		}
+	}
+}
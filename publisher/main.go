@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/checksums"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/chunks"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/filesystem"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/signatures"
+)
+
+const usage = `Usage: publisher <command> [options]
+
+Prepares files in a local directory to be uploaded under an etcd prefix, each file being a key relative to the prefix.
+
+Commands:
+  encrypt     Encrypts a file with a 256 bits key
+  split       Splits a file into chunks and an info key, as the etcd sdk does
+  checksums   Writes the checksums manifest of the directory
+  sign        Writes the signed manifest of the directory
+`
+
+/*
+Reads the files of a directory as a key space, keyed by their path relative to the directory with forward slashes
+*/
+func readKeys(dir string) (map[string]string, error) {
+	keys := map[string]string{}
+
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		rel, relErr := filepath.Rel(dir, file)
+		if relErr != nil {
+			return relErr
+		}
+
+		content, readErr := os.ReadFile(file)
+		if readErr != nil {
+			return readErr
+		}
+
+		keys[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+
+	return keys, err
+}
+
+func writeKey(dir string, key string, value string) error {
+	file := filepath.Join(dir, filepath.FromSlash(key))
+
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, []byte(value), 0644)
+}
+
+func loadPrivateKey(keyFile string) (ed25519.PrivateKey, error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error reading the private key file: %s", err.Error()))
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, errors.New("Private key file must contain a base64 encoded ed25519 private key or seed")
+	}
+
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	default:
+		return nil, errors.New("Private key file must contain a base64 encoded ed25519 private key or seed")
+	}
+}
+
+func encrypt(args []string) error {
+	flags := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	keyFile := flags.String("key", "", "Path to a file containing a 256 bits key in hex or base64 format")
+	input := flags.String("in", "", "Path to the file to encrypt")
+	output := flags.String("out", "", "Path to write the encrypted value to, usually ending with the .enc suffix")
+	flags.Parse(args)
+
+	if *keyFile == "" || *input == "" || *output == "" {
+		return errors.New("The -key, -in and -out options are required")
+	}
+
+	key, err := filesystem.LoadDecryptionKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := filesystem.Encrypt(string(content), key)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(*output, []byte(encrypted), 0644)
+}
+
+func split(args []string) error {
+	flags := flag.NewFlagSet("split", flag.ExitOnError)
+	input := flags.String("in", "", "Path to the file to split")
+	dir := flags.String("dir", "", "Directory to upload to write the chunks and the info in")
+	file := flags.String("file", "", "Path of the file relative to the prefix, with forward slashes")
+	chunkSize := flags.Int64("chunk-size", chunks.DefaultChunkSize, "Maximum size of a chunk in bytes")
+	flags.Parse(args)
+
+	if *input == "" || *dir == "" || *file == "" {
+		return errors.New("The -in, -dir and -file options are required")
+	}
+
+	if *chunkSize <= 0 {
+		return errors.New("The -chunk-size option must be positive")
+	}
+
+	content, err := os.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+
+	infoPath := filepath.Join(*dir, filepath.FromSlash(chunks.GetInfoKey(*file)))
+	previousInfo, err := os.ReadFile(infoPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	chunkKeys, infoKey, info := chunks.Split(*file, content, *chunkSize, chunks.GetNextVersion(string(previousInfo)))
+
+	//As with the etcd sdk, the chunks of the previous version are removed
+	err = os.RemoveAll(filepath.Join(*dir, filepath.FromSlash(*file), "chunks"))
+	if err != nil {
+		return err
+	}
+
+	for key, val := range chunkKeys {
+		err = writeKey(*dir, key, val)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeKey(*dir, infoKey, info)
+}
+
+func writeChecksums(args []string) error {
+	flags := flag.NewFlagSet("checksums", flag.ExitOnError)
+	dir := flags.String("dir", "", "Directory to upload")
+	manifestKey := flags.String("manifest-key", checksums.DefaultManifestKey, "Key of the checksums manifest")
+	flags.Parse(args)
+
+	if *dir == "" {
+		return errors.New("The -dir option is required")
+	}
+
+	keys, err := readKeys(*dir)
+	if err != nil {
+		return err
+	}
+
+	return writeKey(*dir, *manifestKey, checksums.Generate(keys, *manifestKey))
+}
+
+func sign(args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	dir := flags.String("dir", "", "Directory to upload")
+	keyFile := flags.String("key", "", "Path to a file containing a base64 encoded ed25519 private key or seed")
+	manifestKey := flags.String("manifest-key", signatures.DefaultManifestKey, "Key of the signed manifest")
+	version := flags.Int64("version", time.Now().Unix(), "Version of the manifest, which must be greater than the version of previous manifests. Defaults to the current unix time in seconds")
+	flags.Parse(args)
+
+	if *dir == "" || *keyFile == "" {
+		return errors.New("The -dir and -key options are required")
+	}
+
+	privateKey, err := loadPrivateKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	keys, err := readKeys(*dir)
+	if err != nil {
+		return err
+	}
+
+	return writeKey(*dir, *manifestKey, signatures.Sign(keys, *manifestKey, *version, privateKey))
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	commands := map[string]func([]string) error{
+		"encrypt":   encrypt,
+		"split":     split,
+		"checksums": writeChecksums,
+		"sign":      sign,
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	err := command(os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
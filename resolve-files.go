@@ -0,0 +1,532 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/aggregates"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/archives"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/checksums"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/chunks"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/config"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/filesystem"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/logger"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/overrides"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/templates"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+/*
+Files that should be in the directory, as computed from the etcd key space
+*/
+type DesiredFiles struct {
+	//File values keyed by their path relative to the directory with forward slashes
+	Values map[string]string
+	//Files whose content is not available yet (ex: upload in progress) and that should be left as they are, along with everything under them
+	Pending map[string]bool
+	//Directories that archives are extracted to
+	Archives map[string]bool
+}
+
+/*
+Returns true if the file or one of its parent directories is pending
+*/
+func (desired *DesiredFiles) IsPending(file string) bool {
+	for current := file; current != "."; current = path.Dir(current) {
+		if desired.Pending[current] {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Keeps the previous values of files that are pending so that they are neither updated nor deleted until their content is available
+*/
+func (desired *DesiredFiles) KeepPending(previous DesiredFiles) {
+	for file, val := range previous.Values {
+		if desired.IsPending(file) {
+			desired.Values[file] = val
+		}
+	}
+
+	for dir, _ := range previous.Archives {
+		if desired.IsPending(dir) {
+			desired.Archives[dir] = true
+		}
+	}
+}
+
+/*
+Reverts the files of a change that were rejected by the validation to their previous value, or removes them if they are new, so that the desired files match the directory.
+The submitted diff is the change before validation and the accepted diff the change once the rejected files are removed from it.
+*/
+func (desired *DesiredFiles) RevertRejected(previous DesiredFiles, submitted client.KeyDiff, accepted client.KeyDiff) {
+	for _, upserts := range []map[string]string{submitted.Inserts, submitted.Updates} {
+		for file, _ := range upserts {
+			_, inserted := accepted.Inserts[file]
+			_, updated := accepted.Updates[file]
+			if inserted || updated {
+				continue
+			}
+
+			if val, ok := previous.Values[file]; ok {
+				desired.Values[file] = val
+				continue
+			}
+			delete(desired.Values, file)
+		}
+	}
+}
+
+/*
+Returns the directories of archives in any of the given files, sorted.
+The directories of archives that were removed are included as their tree is swapped out all the same.
+*/
+func GetArchiveDirectories(files ...DesiredFiles) []string {
+	dirs := map[string]bool{}
+	for _, desired := range files {
+		for dir, _ := range desired.Archives {
+			dirs[dir] = true
+		}
+	}
+
+	result := []string{}
+	for dir, _ := range dirs {
+		result = append(result, dir)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+/*
+Returns the changes to go from the previous files to the current ones
+*/
+func GetFileChanges(previous map[string]string, current map[string]string) client.WatchInfo {
+	changes := client.WatchInfo{
+		Upserts:   map[string]client.WatchKeyInfo{},
+		Deletions: []string{},
+	}
+
+	for file, _ := range previous {
+		if _, ok := current[file]; !ok {
+			changes.Deletions = append(changes.Deletions, file)
+		}
+	}
+
+	for file, val := range current {
+		if prevVal, ok := previous[file]; !ok || prevVal != val {
+			changes.Upserts[file] = client.WatchKeyInfo{Value: val}
+		}
+	}
+
+	return changes
+}
+
+/*
+Rewrites the directories archives are extracted to along with their files.
+Directories whose files do not all end up under the rewritten directory (ex: flattened files) are no longer swapped as a whole.
+*/
+func rewriteArchiveDirectories(dirs map[string]bool, values map[string]string, rules []filesystem.RewriteRule) map[string]bool {
+	rewritten := map[string]bool{}
+	for dir, _ := range dirs {
+		target := filesystem.RewritePath(dir, rules)
+
+		contained := true
+		for file, _ := range values {
+			if strings.HasPrefix(file, dir+"/") && !strings.HasPrefix(filesystem.RewritePath(file, rules), target+"/") {
+				contained = false
+				break
+			}
+		}
+
+		if contained {
+			rewritten[target] = true
+		}
+	}
+
+	return rewritten
+}
+
+/*
+Computes the files that should be in the directory from the etcd keys, relative to the prefix
+*/
+type FilesResolver struct {
+	Job    config.ConfigJob
+	Log    logger.Logger
+	warned map[string]bool
+	//Templates rendered by the previous resolution, which are only rendered again if the keys they depend on changed
+	templates map[string]*templates.Template
+	//State of the checksums manifest as of the previous resolution, empty if all the keys matched it
+	checksumsState string
+	//Results of the previous resolution for keys whose value is unchanged are reused rather than computed again
+	reassembler chunks.Reassembler
+	decrypted   keyCache[resolvedKey]
+	decoded     keyCache[resolvedKey]
+	extracted   keyCache[*archives.ExpandedArchive]
+}
+
+/*
+File and value a key maps to once a stage of the resolution is applied to it
+*/
+type resolvedKey struct {
+	File  string
+	Value string
+}
+
+type cachedKey[T any] struct {
+	value  string
+	result T
+}
+
+/*
+Results of a stage of the resolution keyed by the key they were computed from.
+A result is reused as long as the value of its key is unchanged and is evicted once its key is no longer in the key space.
+*/
+type keyCache[T any] struct {
+	entries map[string]cachedKey[T]
+	used    map[string]bool
+}
+
+func (c *keyCache[T]) get(key string, value string, compute func() (T, error)) (T, error) {
+	if c.used == nil {
+		c.used = map[string]bool{}
+	}
+	c.used[key] = true
+
+	if entry, ok := c.entries[key]; ok && entry.value == value {
+		return entry.result, nil
+	}
+
+	result, err := compute()
+	if err != nil {
+		return result, err
+	}
+
+	if c.entries == nil {
+		c.entries = map[string]cachedKey[T]{}
+	}
+	c.entries[key] = cachedKey[T]{value: value, result: result}
+
+	return result, nil
+}
+
+/*
+Drops the results of the keys that were not looked up since the last eviction
+*/
+func (c *keyCache[T]) evict() {
+	for key, _ := range c.entries {
+		if !c.used[key] {
+			delete(c.entries, key)
+		}
+	}
+	c.used = map[string]bool{}
+}
+
+/*
+Maps each key of a key space to a file and a value with the given stage, reusing the results of unchanged keys.
+An error is returned if the stage fails for a key or if two keys map to the same file.
+*/
+func (r *FilesResolver) resolveKeys(keys map[string]string, cache *keyCache[resolvedKey], resolve func(string, string) (resolvedKey, error)) (map[string]string, error) {
+	values := map[string]string{}
+	origins := map[string]string{}
+
+	for key, val := range keys {
+		resolved, err := cache.get(key, val, func() (resolvedKey, error) {
+			return resolve(key, val)
+		})
+		if err != nil {
+			return values, err
+		}
+
+		if origin, ok := origins[resolved.File]; ok {
+			return values, errors.New(fmt.Sprintf("Keys %s and %s both map to file %s", origin, key, resolved.File))
+		}
+
+		origins[resolved.File] = key
+		values[resolved.File] = resolved.Value
+	}
+
+	return values, nil
+}
+
+/*
+Reports the keys that were rejected as unsafe paths, either as an error or as warnings depending on the configured policy.
+A given key is only warned about once.
+*/
+func (r *FilesResolver) handleInvalidKeys(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	if r.Job.Filesystem.InvalidKeysPolicy == "fail" {
+		return errs[0]
+	}
+
+	if r.warned == nil {
+		r.warned = map[string]bool{}
+	}
+
+	for _, err := range errs {
+		if !r.warned[err.Error()] {
+			r.Log.Warnf("[Filesystem] Skipping invalid key: %s", err.Error())
+			r.warned[err.Error()] = true
+		}
+	}
+
+	return nil
+}
+
+func (r *FilesResolver) Resolve(keys map[string]string) (DesiredFiles, error) {
+	desired := DesiredFiles{
+		Values:   keys,
+		Pending:  map[string]bool{},
+		Archives: map[string]bool{},
+	}
+
+	if len(r.Job.Filesystem.Signatures.TrustedKeys) > 0 {
+		values := map[string]string{}
+		for key, val := range desired.Values {
+			if key != r.Job.Filesystem.Signatures.ManifestKey {
+				values[key] = val
+			}
+		}
+		desired.Values = values
+	}
+
+	//Keys that do not match the checksums manifest yet (ex: publishing in progress) are pending
+	heldBack := map[string]bool{}
+	if r.Job.Filesystem.Checksums.Enabled {
+		values, keys, checksumsErr := checksums.Verify(desired.Values, r.Job.Filesystem.Checksums.ManifestKey)
+
+		//The state of the manifest is only logged when it changes rather than on every change of the keys
+		state := ""
+		if checksumsErr != nil {
+			state = checksumsErr.Error()
+			if state != r.checksumsState {
+				r.Log.Warnf("[Checksums] Holding back all the keys: %s", checksumsErr.Error())
+			}
+		} else if len(keys) > 0 {
+			state = fmt.Sprintf("%d keys held back", len(keys))
+			if state != r.checksumsState {
+				r.Log.Infof("[Checksums] Holding back %d keys that do not match the manifest", len(keys))
+			}
+		} else if r.checksumsState != "" {
+			r.Log.Infof("[Checksums] All the keys match the manifest")
+		}
+		r.checksumsState = state
+		desired.Values = values
+		heldBack = keys
+	}
+
+	if r.Job.Filesystem.Overrides.Enabled {
+		desired.Values = overrides.ApplyOverrides(desired.Values, r.Job.Filesystem.Overrides.Hostname, r.Job.Filesystem.Overrides.Groups)
+
+		pending := map[string]bool{}
+		for key, _ := range heldBack {
+			if file, ok := overrides.GetOverriddenPath(key, r.Job.Filesystem.Overrides.Hostname, r.Job.Filesystem.Overrides.Groups); ok {
+				pending[file] = true
+			}
+		}
+		heldBack = pending
+	}
+
+	if r.Job.Filesystem.ChunkedFiles {
+		desired.Values, desired.Pending = r.reassembler.Reassemble(desired.Values)
+	}
+
+	for key, _ := range heldBack {
+		if file, ok := chunks.GetChunkedFile(key); ok && r.Job.Filesystem.ChunkedFiles {
+			key = file
+		}
+		desired.Pending[key] = true
+	}
+
+	defer r.decrypted.evict()
+	if r.Job.Filesystem.DecryptionKey != nil {
+		values, decryptErr := r.resolveKeys(desired.Values, &r.decrypted, func(key string, value string) (resolvedKey, error) {
+			file, content, err := filesystem.DecryptValue(key, value, r.Job.Filesystem.DecryptionKey, r.Job.Filesystem.Envelopes)
+			return resolvedKey{File: file, Value: content}, err
+		})
+		if decryptErr != nil {
+			return desired, decryptErr
+		}
+		desired.Values = values
+
+		pending := map[string]bool{}
+		for file, _ := range desired.Pending {
+			pending[filesystem.StripEncryptedSuffix(file)] = true
+		}
+		desired.Pending = pending
+	}
+
+	defer r.decoded.evict()
+	if r.Job.Filesystem.EncodingSuffixes {
+		values, decodeErr := r.resolveKeys(desired.Values, &r.decoded, func(key string, value string) (resolvedKey, error) {
+			file, content, err := filesystem.DecodeKeySuffixes(key, value, r.Job.Filesystem.MaxDecodedSize)
+			return resolvedKey{File: file, Value: content}, err
+		})
+		if decodeErr != nil {
+			return desired, decodeErr
+		}
+		desired.Values = values
+
+		pending := map[string]bool{}
+		for file, _ := range desired.Pending {
+			pending[filesystem.StripKeySuffixes(file)] = true
+		}
+		desired.Pending = pending
+	}
+
+	defer r.extracted.evict()
+	if r.Job.Filesystem.Archives {
+		values := map[string]string{}
+		for key, val := range desired.Values {
+			expanded, extractErr := r.extracted.get(key, val, func() (*archives.ExpandedArchive, error) {
+				limits := archives.Limits{
+					MaxEntrySize: r.Job.Filesystem.ArchiveLimits.MaxEntrySize,
+					MaxTotalSize: r.Job.Filesystem.ArchiveLimits.MaxTotalSize,
+					MaxEntries:   r.Job.Filesystem.ArchiveLimits.MaxEntries,
+				}
+				return archives.ExpandArchive(key, val, r.Job.Filesystem.Envelopes, r.Job.Filesystem.MaxDecodedSize, limits)
+			})
+			if extractErr != nil {
+				return desired, extractErr
+			}
+
+			if expanded == nil {
+				values[key] = val
+				continue
+			}
+
+			desired.Archives[expanded.Dir] = true
+			for file, content := range expanded.Files {
+				values[file] = content
+			}
+		}
+		desired.Values = values
+
+		//An archive that is still being uploaded leaves its whole directory as it is
+		pending := map[string]bool{}
+		for file, _ := range desired.Pending {
+			if dir, _, isArchive := archives.GetArchiveDirectory(file); isArchive {
+				pending[dir] = true
+				desired.Archives[dir] = true
+				continue
+			}
+			pending[file] = true
+		}
+		desired.Pending = pending
+	}
+
+	if r.Job.Filesystem.Templates {
+		//Templates see the same host name as the host overrides, which may be configured explicitly
+		hostname := r.Job.Filesystem.Overrides.Hostname
+		if !r.Job.Filesystem.Overrides.Enabled {
+			var hostErr error
+			hostname, hostErr = os.Hostname()
+			if hostErr != nil {
+				return desired, hostErr
+			}
+		}
+
+		values, rendered, renderErr := templates.RenderTemplates(desired.Values, r.templates, hostname, templates.GetEnv(r.Job.Filesystem.TemplatesEnv))
+		if renderErr != nil {
+			return desired, renderErr
+		}
+
+		for key, tmpl := range rendered {
+			if r.templates[key] != tmpl {
+				r.Log.Debugf("[Templates] Rendered template %s", key)
+			}
+		}
+		r.templates = rendered
+		desired.Values = values
+
+		pending := map[string]bool{}
+		for file, _ := range desired.Pending {
+			pending[strings.TrimSuffix(file, templates.TemplateSuffix)] = true
+		}
+		desired.Pending = pending
+	}
+
+	if len(r.Job.Filesystem.Aggregates) > 0 {
+		values, pending, aggErr := aggregates.ApplyAggregates(desired.Values, desired.Pending, getAggregates(r.Job))
+		if aggErr != nil {
+			return desired, aggErr
+		}
+		desired.Values = values
+		desired.Pending = pending
+	}
+
+	if r.Job.Filesystem.KeyFilterRegex != nil {
+		values := map[string]string{}
+		for file, val := range desired.Values {
+			if r.Job.Filesystem.KeyFilterRegex.MatchString(file) {
+				values[file] = val
+			}
+		}
+		desired.Values = values
+
+		//Archive directories stay pending as the filter applies to the files they contain
+		pending := map[string]bool{}
+		for file, _ := range desired.Pending {
+			if r.Job.Filesystem.KeyFilterRegex.MatchString(file) || desired.Archives[file] {
+				pending[file] = true
+			}
+		}
+		desired.Pending = pending
+	}
+
+	rules := getRewriteRules(r.Job)
+	if len(rules) > 0 {
+		values, rewriteErr := filesystem.RewriteValues(desired.Values, rules)
+		if rewriteErr != nil {
+			return desired, rewriteErr
+		}
+
+		pending := map[string]bool{}
+		for file, _ := range desired.Pending {
+			pending[filesystem.RewritePath(file, rules)] = true
+		}
+
+		desired.Archives = rewriteArchiveDirectories(desired.Archives, desired.Values, rules)
+		desired.Values = values
+		desired.Pending = pending
+	}
+
+	root := getWatchPath(r.Job)
+	values, invalidKeys := filesystem.SanitizeValues(root, desired.Values)
+	invalidErr := r.handleInvalidKeys(invalidKeys)
+	if invalidErr != nil {
+		return desired, invalidErr
+	}
+
+	pending := map[string]bool{}
+	for file, _ := range desired.Pending {
+		sanitized, err := filesystem.SanitizeKey(root, file)
+		if err == nil {
+			pending[sanitized] = true
+		}
+	}
+
+	dirs := map[string]bool{}
+	for dir, _ := range desired.Archives {
+		sanitized, err := filesystem.SanitizeKey(root, dir)
+		if err == nil {
+			dirs[sanitized] = true
+		}
+	}
+
+	desired.Values = values
+	desired.Pending = pending
+	desired.Archives = dirs
+	return desired, nil
+}
@@ -0,0 +1,174 @@
+package signatures
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/filesystem"
+)
+
+const DefaultManifestKey = "__signature"
+
+/*
+Manifest listing the sha256 hash of every key of the prefix, relative to the prefix, signed with an ed25519 private key.
+The signature covers the json serialization of the version and the hashes, with the keys sorted.
+The version increases with each manifest the publisher signs so that older manifests cannot be replayed.
+*/
+type SignedManifest struct {
+	Version   int64             `json:"version"`
+	Keys      map[string]string `json:"keys"`
+	Signature string            `json:"signature"`
+}
+
+type signedPayload struct {
+	Version int64             `json:"version"`
+	Keys    map[string]string `json:"keys"`
+}
+
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func getPayload(version int64, hashes map[string]string) []byte {
+	//Map keys are sorted by the json serialization, which makes the payload deterministic
+	payload, _ := json.Marshal(signedPayload{Version: version, Keys: hashes})
+	return payload
+}
+
+/*
+Returns the signed manifest of the keys with the given version for the publishing side, to be stored in the manifest key once all the keys are written.
+The manifest key itself is left out of the manifest.
+*/
+func Sign(keys map[string]string, manifestKey string, version int64, privateKey ed25519.PrivateKey) string {
+	hashes := map[string]string{}
+	for key, val := range keys {
+		if key != manifestKey {
+			hashes[key] = hashValue(val)
+		}
+	}
+
+	manifest, _ := json.Marshal(SignedManifest{
+		Version:   version,
+		Keys:      hashes,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, getPayload(version, hashes))),
+	})
+
+	return string(manifest)
+}
+
+/*
+Verifies that the keys match exactly the manifest stored in the manifest key, that the manifest is signed by one of the trusted public keys and that its version is not older than the given minimum version.
+Returns the version of the manifest.
+*/
+func Verify(keys map[string]string, manifestKey string, trustedKeys []ed25519.PublicKey, minVersion int64) (int64, error) {
+	value, ok := keys[manifestKey]
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("Signed manifest %s is missing", manifestKey))
+	}
+
+	var manifest SignedManifest
+	err := json.Unmarshal([]byte(value), &manifest)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Signed manifest %s is not valid json: %s", manifestKey, err.Error()))
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(manifest.Signature)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Signature of manifest %s is not valid base64: %s", manifestKey, err.Error()))
+	}
+
+	trusted := false
+	payload := getPayload(manifest.Version, manifest.Keys)
+	for _, publicKey := range trustedKeys {
+		if ed25519.Verify(publicKey, payload, signature) {
+			trusted = true
+			break
+		}
+	}
+
+	if !trusted {
+		return 0, errors.New(fmt.Sprintf("Manifest %s is not signed by a trusted key", manifestKey))
+	}
+
+	//The version is only trusted once the signature is verified
+	if manifest.Version < minVersion {
+		return 0, errors.New(fmt.Sprintf("Manifest %s has version %d, which is older than the last accepted version %d", manifestKey, manifest.Version, minVersion))
+	}
+
+	mismatches := []string{}
+	for key, val := range keys {
+		if key == manifestKey {
+			continue
+		}
+
+		hash, listed := manifest.Keys[key]
+		if !listed || hash != hashValue(val) {
+			mismatches = append(mismatches, key)
+		}
+	}
+
+	for key, _ := range manifest.Keys {
+		if _, ok := keys[key]; !ok {
+			mismatches = append(mismatches, key)
+		}
+	}
+
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		if len(mismatches) > 10 {
+			return 0, errors.New(fmt.Sprintf("Keys do not match the signed manifest: %v and %d more", mismatches[:10], len(mismatches)-10))
+		}
+		return 0, errors.New(fmt.Sprintf("Keys do not match the signed manifest: %v", mismatches))
+	}
+
+	return manifest.Version, nil
+}
+
+/*
+Returns the version of the last accepted manifest persisted in the state file, or 0 if the file does not exist yet
+*/
+func LoadVersion(statePath string) (int64, error) {
+	content, err := os.ReadFile(statePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, errors.New(fmt.Sprintf("Error reading signatures state: %s", err.Error()))
+	}
+
+	version, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Error parsing signatures state: %s", err.Error()))
+	}
+
+	return version, nil
+}
+
+/*
+Durably persists the version of the last accepted manifest in the state file
+*/
+func SaveVersion(statePath string, version int64) error {
+	mkdirErr := os.MkdirAll(filepath.Dir(statePath), 0700)
+	if mkdirErr != nil {
+		return errors.New(fmt.Sprintf("Error creating signatures state directory: %s", mkdirErr.Error()))
+	}
+
+	err := filesystem.WriteFileAtomically(statePath, []byte(strconv.FormatInt(version, 10)), 0600)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error writing signatures state: %s", err.Error()))
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package signatures
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func generateKey(seed byte) (ed25519.PublicKey, ed25519.PrivateKey) {
+	privateKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+	return privateKey.Public().(ed25519.PublicKey), privateKey
+}
+
+func signKeys(keys map[string]string, version int64, privateKey ed25519.PrivateKey) map[string]string {
+	signed := map[string]string{DefaultManifestKey: Sign(keys, DefaultManifestKey, version, privateKey)}
+	for key, val := range keys {
+		signed[key] = val
+	}
+
+	return signed
+}
+
+func withKeys(keys map[string]string, changes map[string]string, deletions ...string) map[string]string {
+	result := map[string]string{}
+	for key, val := range keys {
+		result[key] = val
+	}
+	for key, val := range changes {
+		result[key] = val
+	}
+	for _, key := range deletions {
+		delete(result, key)
+	}
+
+	return result
+}
+
+func TestVerify(t *testing.T) {
+	publicKey, privateKey := generateKey(1)
+	otherPublicKey, otherPrivateKey := generateKey(2)
+
+	keys := map[string]string{"app.conf": "app", "dir/db.conf": "db"}
+	signed := signKeys(keys, 5, privateKey)
+
+	var manifest SignedManifest
+	err := json.Unmarshal([]byte(signed[DefaultManifestKey]), &manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Version = 6
+	replayed, _ := json.Marshal(manifest)
+
+	tests := []struct {
+		name        string
+		keys        map[string]string
+		trustedKeys []ed25519.PublicKey
+		minVersion  int64
+		expected    int64
+		valid       bool
+	}{
+		{"valid manifest", signed, []ed25519.PublicKey{publicKey}, 0, 5, true},
+		{"one of several trusted keys", signed, []ed25519.PublicKey{otherPublicKey, publicKey}, 0, 5, true},
+		{"same version as the last accepted one", signed, []ed25519.PublicKey{publicKey}, 5, 5, true},
+		{"older version than the last accepted one", signed, []ed25519.PublicKey{publicKey}, 6, 0, false},
+		{"untrusted key", signKeys(keys, 5, otherPrivateKey), []ed25519.PublicKey{publicKey}, 0, 0, false},
+		{"no trusted keys", signed, []ed25519.PublicKey{}, 0, 0, false},
+		{"version altered after signing", withKeys(signed, map[string]string{DefaultManifestKey: string(replayed)}), []ed25519.PublicKey{publicKey}, 0, 0, false},
+		{"modified key", withKeys(signed, map[string]string{"app.conf": "changed"}), []ed25519.PublicKey{publicKey}, 0, 0, false},
+		{"added key", withKeys(signed, map[string]string{"extra.conf": "extra"}), []ed25519.PublicKey{publicKey}, 0, 0, false},
+		{"removed key", withKeys(signed, map[string]string{}, "dir/db.conf"), []ed25519.PublicKey{publicKey}, 0, 0, false},
+		{"missing manifest", keys, []ed25519.PublicKey{publicKey}, 0, 0, false},
+		{"invalid manifest", withKeys(keys, map[string]string{DefaultManifestKey: "not json"}), []ed25519.PublicKey{publicKey}, 0, 0, false},
+		{
+			"signature that is not base64",
+			withKeys(keys, map[string]string{DefaultManifestKey: `{"version":1,"keys":{},"signature":"not base64!"}`}),
+			[]ed25519.PublicKey{publicKey},
+			0,
+			0,
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version, err := Verify(test.keys, DefaultManifestKey, test.trustedKeys, test.minVersion)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("Verify() = %d, expected an error", version)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if version != test.expected {
+				t.Errorf("Verify() = %d, expected %d", version, test.expected)
+			}
+		})
+	}
+}
+
+func TestSaveVersion(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state", "signatures")
+
+	version, err := LoadVersion(statePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if version != 0 {
+		t.Errorf("LoadVersion() = %d for a missing state file, expected 0", version)
+	}
+
+	err = SaveVersion(statePath, 42)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	version, err = LoadVersion(statePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if version != 42 {
+		t.Errorf("LoadVersion() = %d, expected 42", version)
+	}
+
+	err = os.WriteFile(statePath, []byte("not a version"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadVersion(statePath)
+	if err == nil {
+		t.Errorf("Expected an error for an invalid state file")
+	}
+}
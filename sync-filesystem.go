@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/aggregates"
 	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/cmd"
 	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/config"
 	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/filesystem"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/journal"
 	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/logger"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/signatures"
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/validation"
 
 	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
 )
@@ -16,9 +22,217 @@ type SyncFsFeedback struct {
 	Error error
 }
 
-func SyncFilesystem(conf config.Config, proceedChan <-chan struct{}, log logger.Logger) (context.CancelFunc, <-chan SyncFsFeedback) {
+func getContentPath(job config.ConfigJob) (string, error) {
+	if job.Filesystem.Swap.Enabled {
+		return filesystem.GetSwapDataDir(job.Filesystem.Path)
+	}
+
+	return job.Filesystem.Path, nil
+}
+
+/*
+Path the keys reported by etcd watches are relative to.
+In swap mode, it resolves through the data symlink so that it is valid even before the first version is materialized.
+*/
+func getWatchPath(job config.ConfigJob) string {
+	if job.Filesystem.Swap.Enabled {
+		return filepath.Join(job.Filesystem.Path, filesystem.SwapDataLink)
+	}
+
+	return job.Filesystem.Path
+}
+
+func getDirectoryHashes(job config.ConfigJob, manifest *filesystem.Manifest) (map[string]string, error) {
+	contentPath, err := getContentPath(job)
+	if err != nil || contentPath == "" {
+		return map[string]string{}, err
+	}
+
+	hashes, hashErr := filesystem.GetDirectoryHashes(contentPath, job.Filesystem.Exclude)
+	if hashErr != nil {
+		return map[string]string{}, hashErr
+	}
+
+	if manifest != nil {
+		hashes = manifest.FilterValues(hashes)
+	}
+
+	return hashes, nil
+}
+
+func getPermissionRules(job config.ConfigJob) []filesystem.PermissionRule {
+	rules := []filesystem.PermissionRule{}
+	for _, rule := range job.Filesystem.PermissionRules {
+		mode := os.FileMode(0)
+		if rule.Mode != "" {
+			mode = filesystem.ConvertFileMode(rule.Mode)
+		}
+
+		rules = append(rules, filesystem.PermissionRule{
+			Pattern: rule.Pattern,
+			Mode:    mode,
+			Uid:     rule.Uid,
+			Gid:     rule.Gid,
+		})
+	}
+
+	return rules
+}
+
+func getRewriteRules(job config.ConfigJob) []filesystem.RewriteRule {
+	rules := []filesystem.RewriteRule{}
+	for _, rule := range job.Filesystem.RewriteRules {
+		rules = append(rules, filesystem.RewriteRule{
+			Type:      rule.Type,
+			Match:     rule.MatchRegex,
+			Replace:   rule.Replace,
+			Extension: rule.Extension,
+			Separator: rule.Separator,
+		})
+	}
+
+	return rules
+}
+
+func getAggregates(job config.ConfigJob) []aggregates.Aggregate {
+	aggs := []aggregates.Aggregate{}
+	for _, agg := range job.Filesystem.Aggregates {
+		aggs = append(aggs, aggregates.Aggregate{
+			File:      agg.File,
+			Prefix:    agg.Prefix,
+			Format:    agg.Format,
+			Separator: *agg.Separator,
+		})
+	}
+
+	return aggs
+}
+
+/*
+Validates the syntax of the files the diff inserts or updates.
+Returns the diff without the invalid files along with the validation errors of the invalid files.
+*/
+func validateDiff(diff client.KeyDiff, opts filesystem.ApplyOptions) (client.KeyDiff, []error, error) {
+	invalid := map[string]bool{}
+	validationErrs := []error{}
+	for _, upserts := range []map[string]string{diff.Inserts, diff.Updates} {
+		for file, val := range upserts {
+			content, _, resolveErr := opts.ResolveValue(file, val)
+			if resolveErr != nil {
+				return diff, validationErrs, resolveErr
+			}
+
+			validationErr := validation.ValidateFile(file, content)
+			if validationErr != nil {
+				invalid[file] = true
+				validationErrs = append(validationErrs, validationErr)
+			}
+		}
+	}
+
+	valid := diff.FilterKeys(func(key string) bool {
+		return !invalid[key]
+	})
+
+	return *valid, validationErrs, nil
+}
+
+/*
+Adds the files of an interrupted change that the diff does not touch to the diff, with their current value, so that they are notified along with the diff.
+Files of the interrupted change that no longer have a value are reported as deleted.
+*/
+func mergeInterruptedDiff(diff client.KeyDiff, interrupted journal.Entry, values map[string]string) client.KeyDiff {
+	merged := client.KeyDiff{
+		Inserts:   map[string]string{},
+		Updates:   map[string]string{},
+		Deletions: append([]string{}, diff.Deletions...),
+	}
+
+	touched := map[string]bool{}
+	for key, val := range diff.Inserts {
+		merged.Inserts[key] = val
+		touched[key] = true
+	}
+	for key, val := range diff.Updates {
+		merged.Updates[key] = val
+		touched[key] = true
+	}
+	for _, key := range diff.Deletions {
+		touched[key] = true
+	}
+
+	inserted := map[string]bool{}
+	for _, key := range interrupted.Inserts {
+		inserted[key] = true
+	}
+
+	keys := append([]string{}, interrupted.Inserts...)
+	keys = append(keys, interrupted.Updates...)
+	keys = append(keys, interrupted.Deletions...)
+
+	for _, key := range keys {
+		if touched[key] {
+			continue
+		}
+		touched[key] = true
+
+		val, ok := values[key]
+		if !ok {
+			merged.Deletions = append(merged.Deletions, key)
+			continue
+		}
+
+		if inserted[key] {
+			merged.Inserts[key] = val
+			continue
+		}
+		merged.Updates[key] = val
+	}
+
+	return merged
+}
+
+func applyDiff(job config.ConfigJob, diff client.KeyDiff, opts filesystem.ApplyOptions) error {
+	if job.Filesystem.Swap.Enabled {
+		return filesystem.ApplyDiffWithSwap(job.Filesystem.Path, diff, opts, job.Filesystem.Swap.KeptVersions)
+	}
+
+	return filesystem.ApplyDiffToDirectory(job.Filesystem.Path, diff, opts)
+}
+
+/*
+Connects to the etcd cluster. The connection is shared by all the jobs.
+*/
+func ConnectToEtcd(ctx context.Context, conf config.Config) (*client.EtcdClient, error) {
+	return client.Connect(ctx, client.EtcdClientOptions{
+		ClientCertPath:    conf.EtcdClient.Auth.ClientCert,
+		ClientKeyPath:     conf.EtcdClient.Auth.ClientKey,
+		ClientCertKeyPath: conf.EtcdClient.Auth.ClientCertKey,
+		CaCertPath:        conf.EtcdClient.Auth.CaCert,
+		Username:          conf.EtcdClient.Auth.Username,
+		Password:          conf.EtcdClient.Auth.Password,
+		EtcdEndpoints:     conf.EtcdClient.Endpoints,
+		ConnectionTimeout: conf.EtcdClient.ConnectionTimeout,
+		RequestTimeout:    conf.EtcdClient.RequestTimeout,
+		RetryInterval:     conf.EtcdClient.RetryInterval,
+		Retries:           conf.EtcdClient.Retries,
+	})
+}
+
+/*
+Returns a copy of the shared etcd client with a different context, so that a job can be cancelled without affecting the other jobs sharing the connection.
+The SetContext method of the etcd sdk does not carry over the retry interval, which would otherwise make the retries of the copy immediate.
+*/
+func SetClientContext(etcdCli *client.EtcdClient, ctx context.Context) *client.EtcdClient {
+	cli := etcdCli.SetContext(ctx)
+	cli.RetryInterval = etcdCli.RetryInterval
+	return cli
+}
+
+func SyncFilesystem(job config.ConfigJob, etcdCli *client.EtcdClient, proceedChan <-chan struct{}, log logger.Logger) (context.CancelFunc, <-chan SyncFsFeedback) {
 	feedbackChan := make(chan SyncFsFeedback)
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(etcdCli.Context)
+	cli := SetClientContext(etcdCli, ctx)
 
 	go func() {
 		defer func() {
@@ -26,111 +240,393 @@ func SyncFilesystem(conf config.Config, proceedChan <-chan struct{}, log logger.
 			cancel()
 		}()
 
-		fsErr := filesystem.EnsureFilesystemDir(conf.Filesystem.Path, filesystem.ConvertFileMode(conf.Filesystem.DirectoriesPermission))
+		fsErr := filesystem.EnsureFilesystemDir(job.Filesystem.Path, filesystem.ConvertFileMode(job.Filesystem.DirectoriesPermission))
 		if fsErr != nil {
 			feedbackChan <- SyncFsFeedback{Error: fsErr}
 			return
 		}
 
-		cli, cliErr := client.Connect(ctx, client.EtcdClientOptions{
-			ClientCertPath:    conf.EtcdClient.Auth.ClientCert,
-			ClientKeyPath:     conf.EtcdClient.Auth.ClientKey,
-			ClientCertKeyPath: conf.EtcdClient.Auth.ClientCertKey,
-			CaCertPath:        conf.EtcdClient.Auth.CaCert,
-			Username:          conf.EtcdClient.Auth.Username,
-			Password:          conf.EtcdClient.Auth.Password,
-			EtcdEndpoints:     conf.EtcdClient.Endpoints,
-			ConnectionTimeout: conf.EtcdClient.ConnectionTimeout,
-			RequestTimeout:    conf.EtcdClient.RequestTimeout,
-			RetryInterval:     conf.EtcdClient.RetryInterval,
-			Retries:           conf.EtcdClient.Retries,
-		})
-
-		if cliErr != nil {
-			feedbackChan <- SyncFsFeedback{Error: cliErr}
+		cleanupErr := filesystem.CleanupRollbackDirectories(job.Filesystem.Path)
+		if cleanupErr != nil {
+			feedbackChan <- SyncFsFeedback{Error: cleanupErr}
 			return
 		}
-		defer cli.Client.Close()
 
-		prefixInfo, prefixErr := cli.GetPrefix(conf.EtcdClient.Prefix)
-		if prefixErr != nil {
-			feedbackChan <- SyncFsFeedback{Error: prefixErr}
-			return
+		if job.Filesystem.Swap.Enabled && !job.Filesystem.ManagedFilesOnly {
+			swapErr := filesystem.CheckSwapDirectory(job.Filesystem.Path, job.Filesystem.Exclude)
+			if swapErr != nil {
+				feedbackChan <- SyncFsFeedback{Error: swapErr}
+				return
+			}
 		}
 
-		dirKeys, dirErr := filesystem.GetDirectoryContent(conf.Filesystem.Path)
-		if dirErr != nil {
-			feedbackChan <- SyncFsFeedback{Error: dirErr}
-			return
+		applyOpts := filesystem.ApplyOptions{
+			FilesPermission:       filesystem.ConvertFileMode(job.Filesystem.FilesPermission),
+			DirectoriesPermission: filesystem.ConvertFileMode(job.Filesystem.DirectoriesPermission),
+			Exclude:               job.Filesystem.Exclude,
+			PermissionRules:       getPermissionRules(job),
+			Envelopes:             job.Filesystem.Envelopes,
+			MaxDecodedSize:        job.Filesystem.MaxDecodedSize,
 		}
 
-		diff := client.GetKeyDiff(
-			prefixInfo.Keys.ToValueMap(conf.EtcdClient.Prefix),
-			dirKeys.ToValueMap(conf.Filesystem.SlashPath),
-		)
+		if job.Filesystem.ManagedFilesOnly {
+			manifest, manifestErr := filesystem.LoadManifest(job.Filesystem.Path)
+			if manifestErr != nil {
+				feedbackChan <- SyncFsFeedback{Error: manifestErr}
+				return
+			}
+			applyOpts.Manifest = manifest
+		}
 
-		if !diff.IsEmpty() {
+		jrnl := journal.Journal{Path: job.JournalPath}
+		interrupted, journalErr := jrnl.Read()
+		if journalErr != nil {
+			feedbackChan <- SyncFsFeedback{Error: journalErr}
+			return
+		}
+
+		notify := func(diff client.KeyDiff) bool {
 			feedbackChan <- SyncFsFeedback{Diff: diff}
 			if proceedChan != nil {
 				_, ok := <-proceedChan
-				if !ok {
-					return
+				return ok
+			}
+			return true
+		}
+
+		runHooks := func(entry journal.Entry) error {
+			if len(job.NotificationCommand) > 0 {
+				cmdErr := cmd.ExecCommand(job.NotificationCommand, job.NotificationCommandRetries)
+				if cmdErr != nil {
+					return cmdErr
 				}
 			}
 
-			applyErr := filesystem.ApplyDiffToDirectory(conf.Filesystem.Path, diff, filesystem.ConvertFileMode(conf.Filesystem.FilesPermission), filesystem.ConvertFileMode(conf.Filesystem.DirectoriesPermission))
-			if applyErr != nil {
-				feedbackChan <- SyncFsFeedback{Error: applyErr}
-				return
+			entry.HooksCompleted = true
+			return jrnl.Write(entry)
+		}
+
+		//Applies the diff and runs the hooks of the notified diff, which can include files of an interrupted change on top of the diff
+		applyChange := func(diff client.KeyDiff, notified client.KeyDiff, revision int64) error {
+			entry := journal.NewEntry(revision, notified)
+			writeErr := jrnl.Write(entry)
+			if writeErr != nil {
+				return writeErr
 			}
 
-			if len(conf.NotificationCommand) > 0 {
-				cmdErr := cmd.ExecCommand(conf.NotificationCommand, conf.NotificationCommandRetries)
-				if cmdErr != nil {
-					feedbackChan <- SyncFsFeedback{Error: cmdErr}
-					return
+			if !diff.IsEmpty() {
+				applyErr := applyDiff(job, diff, applyOpts)
+				if applyErr != nil {
+					return applyErr
 				}
 			}
+
+			return runHooks(entry)
+		}
+
+		layers, revision, layersErr := GetLayers(cli, job.Prefixes)
+		if layersErr != nil {
+			feedbackChan <- SyncFsFeedback{Error: layersErr}
+			return
 		}
 
-		wOpts := client.WatchOptions{
-			Revision:   prefixInfo.Revision + 1,
-			IsPrefix:   true,
-			TrimPrefix: true,
+		//If the keys cannot be trusted or the files are rejected on startup, the whole directory is synchronized on a later change
+		resync := false
+		//Existing files are only adopted in the manifest on the first synchronization of the directory
+		adoptFiles := job.Filesystem.ManagedFilesOnly
+
+		//Returns the diff without the files rejected by the validation and false if the whole diff is rejected
+		validate := func(diff client.KeyDiff) (client.KeyDiff, bool, error) {
+			if !job.Filesystem.Validation.Enabled {
+				return diff, true, nil
+			}
+
+			valid, validationErrs, validateErr := validateDiff(diff, applyOpts)
+			if validateErr != nil {
+				return diff, false, validateErr
+			}
+
+			for _, validationErr := range validationErrs {
+				log.Errorf("[Validation] %s", validationErr.Error())
+			}
+
+			if len(validationErrs) > 0 && job.Filesystem.Validation.Policy == validation.PolicyRejectDiff {
+				log.Errorf("[Validation] Rejecting the whole change until all its files are valid")
+				return diff, false, nil
+			}
+
+			return valid, true, nil
 		}
-		changeChan := cli.Watch(conf.EtcdClient.Prefix, wOpts)
+
+		//Synchronizes the whole directory with the desired files, rather than applying the changes between two sets of desired files
+		//Returns false if the job should stop
+		syncDirectory := func(desired DesiredFiles, revision int64) (bool, error) {
+			if adoptFiles {
+				allHashes, hashesErr := getDirectoryHashes(job, nil)
+				if hashesErr != nil {
+					return false, hashesErr
+				}
+
+				adopted, adoptErr := applyOpts.Manifest.AdoptMatchingFiles(desired.Values, allHashes, applyOpts)
+				if adoptErr != nil {
+					return false, adoptErr
+				}
+
+				if len(adopted) > 0 {
+					log.Infof("[Filesystem] Added %d existing files matching their key to the managed files", len(adopted))
+				}
+				adoptFiles = false
+			}
+
+			dirHashes, dirErr := getDirectoryHashes(job, applyOpts.Manifest)
+			if dirErr != nil {
+				return false, dirErr
+			}
+
+			diff, diffErr := filesystem.GetHashDiff(desired.Values, dirHashes, applyOpts)
+			if diffErr != nil {
+				return false, diffErr
+			}
+			diff = *diff.FilterKeys(func(key string) bool {
+				return !desired.IsPending(key) && !filesystem.IsExcluded(key, job.Filesystem.Exclude)
+			})
+			applyOpts.AtomicDirectories = GetArchiveDirectories(desired)
+
+			submitted := diff
+			diff, accepted, validateErr := validate(diff)
+			if validateErr != nil {
+				return false, validateErr
+			}
+
+			if !accepted {
+				resync = true
+				return true, nil
+			}
+
+			//The previous content of rejected files is not known here, so the directory is synchronized again on the next change
+			if len(diff.Inserts)+len(diff.Updates) < len(submitted.Inserts)+len(submitted.Updates) {
+				resync = true
+			}
+
+			notified := diff
+			if interrupted != nil && interrupted.IsPending() {
+				//The hooks of the change that was interrupted are completed along with the diff, including for the files the diff does not touch
+				log.Warnf("[Journal] Completing hooks of change interrupted at revision %d", interrupted.Revision)
+				notified = mergeInterruptedDiff(diff, *interrupted, desired.Values)
+				notified = *notified.FilterKeys(func(key string) bool {
+					return !desired.IsPending(key) && !filesystem.IsExcluded(key, job.Filesystem.Exclude)
+				})
+				interrupted = nil
+			}
+
+			if !notified.IsEmpty() {
+				if !notify(notified) {
+					return false, nil
+				}
+
+				applyErr := applyChange(diff, notified, revision)
+				if applyErr != nil {
+					return false, applyErr
+				}
+			}
+
+			unchanged := map[string]filesystem.FileAttributes{}
+			for key, val := range desired.Values {
+				_, inserted := diff.Inserts[key]
+				_, updated := diff.Updates[key]
+				managed := applyOpts.Manifest == nil || applyOpts.Manifest.Contains(key)
+				if inserted || updated || !managed || filesystem.IsExcluded(key, job.Filesystem.Exclude) {
+					continue
+				}
+
+				_, attrs, resolveErr := applyOpts.ResolveValue(key, val)
+				if resolveErr != nil {
+					return false, resolveErr
+				}
+				unchanged[key] = attrs
+			}
+
+			contentPath, pathErr := getContentPath(job)
+			if pathErr != nil {
+				return false, pathErr
+			}
+
+			if contentPath != "" {
+				corrected, attrsErr := filesystem.EnforceFileAttributes(contentPath, unchanged)
+				if attrsErr != nil {
+					return false, attrsErr
+				}
+
+				if len(corrected) > 0 {
+					log.Infof("[Filesystem] Corrected the permission or ownership of %d files", len(corrected))
+				}
+			}
+
+			if !job.Filesystem.Swap.Enabled && !job.Filesystem.ManagedFilesOnly {
+				removedDirs, pruneErr := filesystem.RemoveEmptyDirectories(job.Filesystem.Path, job.Filesystem.Exclude)
+				if pruneErr != nil {
+					return false, pruneErr
+				}
+
+				if len(removedDirs) > 0 {
+					log.Infof("[Filesystem] Removed %d stray empty directories", len(removedDirs))
+				}
+			}
+
+			return true, nil
+		}
+
+		resolver := FilesResolver{Job: job, Log: log}
+		//The version of the last accepted manifest is persisted so that older manifests are refused, including across restarts
+		signedVersion := int64(0)
+		if len(job.Filesystem.Signatures.TrustedKeys) > 0 {
+			var versionErr error
+			signedVersion, versionErr = signatures.LoadVersion(job.Filesystem.Signatures.StatePath)
+			if versionErr != nil {
+				feedbackChan <- SyncFsFeedback{Error: versionErr}
+				return
+			}
+		}
+
+		//Returns the version of the manifest the keys match, if signatures are enabled
+		verifyKeys := func(keys map[string]string) (int64, error) {
+			if len(job.Filesystem.Signatures.TrustedKeys) == 0 {
+				return 0, nil
+			}
+
+			return signatures.Verify(keys, job.Filesystem.Signatures.ManifestKey, job.Filesystem.Signatures.TrustedKeys, signedVersion)
+		}
+
+		acceptVersion := func(version int64) error {
+			if version <= signedVersion {
+				return nil
+			}
+
+			saveErr := signatures.SaveVersion(job.Filesystem.Signatures.StatePath, version)
+			if saveErr != nil {
+				return saveErr
+			}
+			signedVersion = version
+
+			return nil
+		}
+
+		desired := DesiredFiles{Values: map[string]string{}, Pending: map[string]bool{}, Archives: map[string]bool{}}
+		keys := layers.Merge()
+		version, verifyErr := verifyKeys(keys)
+		if verifyErr != nil {
+			log.Warnf("[Signatures] Not synchronizing the directory until the keys match a trusted manifest: %s", verifyErr.Error())
+			resync = true
+		} else {
+			acceptErr := acceptVersion(version)
+			if acceptErr != nil {
+				feedbackChan <- SyncFsFeedback{Error: acceptErr}
+				return
+			}
+
+			var resolveErr error
+			desired, resolveErr = resolver.Resolve(keys)
+			if resolveErr != nil {
+				feedbackChan <- SyncFsFeedback{Error: resolveErr}
+				return
+			}
+
+			proceed, syncErr := syncDirectory(desired, revision)
+			if syncErr != nil {
+				feedbackChan <- SyncFsFeedback{Error: syncErr}
+				return
+			}
+
+			if !proceed {
+				return
+			}
+		}
+
+		changeChan := WatchLayers(ctx, cli, job.Prefixes, revision)
 		for res := range changeChan {
 			if res.Error != nil {
 				feedbackChan <- SyncFsFeedback{Error: res.Error}
 				return
 			}
 
-			diff, diffErr := filesystem.WatchInfoToKeyDiffs(conf.Filesystem.Path, res.Changes)
-			if diffErr != nil {
-				feedbackChan <- SyncFsFeedback{Error: diffErr}
+			revision = res.Revision
+			for idx, _ := range res.Changes {
+				res.Changes[idx].ApplyOn(layers.Layers[idx])
+			}
+			keys := layers.Merge()
+			version, verifyErr := verifyKeys(keys)
+			if verifyErr != nil {
+				log.Warnf("[Signatures] Refusing to apply change at revision %d: %s", revision, verifyErr.Error())
+				continue
+			}
+
+			acceptErr := acceptVersion(version)
+			if acceptErr != nil {
+				feedbackChan <- SyncFsFeedback{Error: acceptErr}
 				return
 			}
 
-			feedbackChan <- SyncFsFeedback{Diff: diff}
-			if proceedChan != nil {
-				_, ok := <-proceedChan
-				if !ok {
+			current, resolveErr := resolver.Resolve(keys)
+			if resolveErr != nil {
+				feedbackChan <- SyncFsFeedback{Error: resolveErr}
+				return
+			}
+
+			if resync {
+				resync = false
+				desired = current
+				proceed, syncErr := syncDirectory(current, revision)
+				if syncErr != nil {
+					feedbackChan <- SyncFsFeedback{Error: syncErr}
 					return
 				}
+
+				if !proceed {
+					return
+				}
+				continue
 			}
+			current.KeepPending(desired)
 
-			applyErr := filesystem.ApplyDiffToDirectory(conf.Filesystem.Path, diff, filesystem.ConvertFileMode(conf.Filesystem.FilesPermission), filesystem.ConvertFileMode(conf.Filesystem.DirectoriesPermission))
-			if applyErr != nil {
-				feedbackChan <- SyncFsFeedback{Error: applyErr}
+			changes := GetFileChanges(desired.Values, current.Values)
+			applyOpts.AtomicDirectories = GetArchiveDirectories(desired, current)
+
+			diff, diffErr := filesystem.WatchInfoToKeyDiffs(getWatchPath(job), changes, applyOpts)
+			if diffErr != nil {
+				feedbackChan <- SyncFsFeedback{Error: diffErr}
 				return
 			}
 
-			if len(conf.NotificationCommand) > 0 {
-				cmdErr := cmd.ExecCommand(conf.NotificationCommand, conf.NotificationCommandRetries)
-				if cmdErr != nil {
-					feedbackChan <- SyncFsFeedback{Error: cmdErr}
-					return
-				}
+			diff = *diff.FilterKeys(filesystem.GetExcludeFilter(job.Filesystem.Exclude))
+			submitted := diff
+			diff, accepted, validateErr := validate(diff)
+			if validateErr != nil {
+				feedbackChan <- SyncFsFeedback{Error: validateErr}
+				return
+			}
+
+			//The files of a rejected change are not considered applied so that the whole change is applied once it is valid
+			if !accepted {
+				continue
+			}
+
+			//Likewise, rejected files keep their previous value so that they are validated again on the next change
+			current.RevertRejected(desired, submitted, diff)
+			desired = current
+
+			if diff.IsEmpty() {
+				log.Debugf("[Etcd] Ignoring change that leaves the directory unchanged")
+				continue
+			}
+
+			if !notify(diff) {
+				return
+			}
+
+			applyErr := applyChange(diff, diff, revision)
+			if applyErr != nil {
+				feedbackChan <- SyncFsFeedback{Error: applyErr}
+				return
 			}
 		}
 		log.Infof("[Etcd] Etcd watch stopped")
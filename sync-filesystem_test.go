@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Ferlab-Ste-Justine/configurations-auto-updater/journal"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+func TestMergeInterruptedDiff(t *testing.T) {
+	tests := []struct {
+		name        string
+		diff        client.KeyDiff
+		interrupted journal.Entry
+		values      map[string]string
+		expected    client.KeyDiff
+	}{
+		{
+			name:        "nothing interrupted",
+			diff:        client.KeyDiff{Inserts: map[string]string{"a": "a"}, Updates: map[string]string{}, Deletions: []string{"b"}},
+			interrupted: journal.Entry{},
+			values:      map[string]string{"a": "a"},
+			expected:    client.KeyDiff{Inserts: map[string]string{"a": "a"}, Updates: map[string]string{}, Deletions: []string{"b"}},
+		},
+		{
+			name:        "interrupted files added with their current value",
+			diff:        client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{}, Deletions: []string{}},
+			interrupted: journal.Entry{Inserts: []string{"a"}, Updates: []string{"b"}, Deletions: []string{"c"}},
+			values:      map[string]string{"a": "a", "b": "b", "c": "c"},
+			expected:    client.KeyDiff{Inserts: map[string]string{"a": "a"}, Updates: map[string]string{"b": "b", "c": "c"}, Deletions: []string{}},
+		},
+		{
+			name:        "interrupted files without a value reported as deleted",
+			diff:        client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{}, Deletions: []string{}},
+			interrupted: journal.Entry{Inserts: []string{"a"}, Deletions: []string{"b"}},
+			values:      map[string]string{},
+			expected:    client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{}, Deletions: []string{"a", "b"}},
+		},
+		{
+			name:        "files touched by the diff keep the change of the diff",
+			diff:        client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{"a": "new"}, Deletions: []string{"b"}},
+			interrupted: journal.Entry{Inserts: []string{"a"}, Updates: []string{"b"}},
+			values:      map[string]string{"a": "new"},
+			expected:    client.KeyDiff{Inserts: map[string]string{}, Updates: map[string]string{"a": "new"}, Deletions: []string{"b"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := mergeInterruptedDiff(test.diff, test.interrupted, test.values)
+			sort.Strings(merged.Deletions)
+			if !reflect.DeepEqual(merged, test.expected) {
+				t.Errorf("mergeInterruptedDiff() = %v, expected %v", merged, test.expected)
+			}
+		})
+	}
+}
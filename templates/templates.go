@@ -0,0 +1,248 @@
+package templates
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const TemplateSuffix = ".tmpl"
+
+/*
+Keys a template read while it was rendered, either individually or as part of a prefix
+*/
+type Dependencies struct {
+	Keys     map[string]bool
+	Prefixes map[string]bool
+}
+
+/*
+Returns a digest of the values of the dependencies in the key space, which changes whenever a dependency is added, updated or removed
+*/
+func (deps *Dependencies) Digest(keys map[string]string) string {
+	entries := []string{}
+	for key, _ := range deps.Keys {
+		val, ok := keys[key]
+		entries = append(entries, fmt.Sprintf("key\x00%s\x00%t\x00%s", key, ok, val))
+	}
+
+	for prefix, _ := range deps.Prefixes {
+		for key, val := range keys {
+			if strings.HasPrefix(key, prefix) {
+				entries = append(entries, fmt.Sprintf("prefix\x00%s\x00%s\x00%s", prefix, key, val))
+			}
+		}
+		entries = append(entries, fmt.Sprintf("prefix\x00%s", prefix))
+	}
+	sort.Strings(entries)
+
+	hash := sha256.New()
+	for _, entry := range entries {
+		hash.Write([]byte(entry))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/*
+Rendered template along with what is needed to tell if it should be rendered again
+*/
+type Template struct {
+	Source       string
+	Output       string
+	Dependencies Dependencies
+	Digest       string
+}
+
+/*
+Returns true if the template's source or any of the keys it depends on changed since it was rendered
+*/
+func (tmpl *Template) IsStale(source string, keys map[string]string) bool {
+	return tmpl.Source != source || tmpl.Dependencies.Digest(keys) != tmpl.Digest
+}
+
+/*
+Values available to templates as the dot
+*/
+type TemplateData struct {
+	//Path of the rendered file relative to the directory
+	File     string
+	Hostname string
+	Env      map[string]string
+}
+
+/*
+Returns the environment variables with the given names, to expose to templates.
+Variables that are not set have an empty value.
+*/
+func GetEnv(names []string) map[string]string {
+	env := map[string]string{}
+	for _, name := range names {
+		env[name] = os.Getenv(name)
+	}
+
+	return env
+}
+
+func getFuncs(hostname string, env map[string]string, keys map[string]string, deps *Dependencies) template.FuncMap {
+	return template.FuncMap{
+		"hostname": func() string {
+			return hostname
+		},
+		"env": func(name string) (string, error) {
+			val, ok := env[name]
+			if !ok {
+				return "", errors.New(fmt.Sprintf("Environment variable %s is not exposed to templates", name))
+			}
+			return val, nil
+		},
+		"key": func(key string) (string, error) {
+			deps.Keys[key] = true
+			val, ok := keys[key]
+			if !ok {
+				return "", errors.New(fmt.Sprintf("Key %s does not exist", key))
+			}
+			return val, nil
+		},
+		"keyOr": func(key string, defaultVal string) string {
+			deps.Keys[key] = true
+			val, ok := keys[key]
+			if !ok {
+				return defaultVal
+			}
+			return val
+		},
+		"hasKey": func(key string) bool {
+			deps.Keys[key] = true
+			_, ok := keys[key]
+			return ok
+		},
+		"keys": func(prefix string) map[string]string {
+			deps.Prefixes[prefix] = true
+			result := map[string]string{}
+			for key, val := range keys {
+				if strings.HasPrefix(key, prefix) {
+					result[strings.TrimPrefix(key, prefix)] = val
+				}
+			}
+			return result
+		},
+		"default": func(defaultVal string, val string) string {
+			if val == "" {
+				return defaultVal
+			}
+			return val
+		},
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix string, val string) string { return strings.TrimPrefix(val, prefix) },
+		"trimSuffix": func(suffix string, val string) string { return strings.TrimSuffix(val, suffix) },
+		"replace":    func(old string, new string, val string) string { return strings.ReplaceAll(val, old, new) },
+		"contains":   func(substr string, val string) bool { return strings.Contains(val, substr) },
+		"hasPrefix":  func(prefix string, val string) bool { return strings.HasPrefix(val, prefix) },
+		"hasSuffix":  func(suffix string, val string) bool { return strings.HasSuffix(val, suffix) },
+		"split":      func(sep string, val string) []string { return strings.Split(val, sep) },
+		"join":       func(sep string, vals []string) string { return strings.Join(vals, sep) },
+		"lines": func(val string) []string {
+			return strings.Split(strings.TrimRight(val, "\n"), "\n")
+		},
+		"indent": func(spaces int, val string) string {
+			pad := strings.Repeat(" ", spaces)
+			return pad + strings.ReplaceAll(val, "\n", "\n"+pad)
+		},
+		"b64enc": func(val string) string {
+			return base64.StdEncoding.EncodeToString([]byte(val))
+		},
+		"b64dec": func(val string) (string, error) {
+			decoded, err := base64.StdEncoding.DecodeString(val)
+			return string(decoded), err
+		},
+		"toJson": func(val interface{}) (string, error) {
+			encoded, err := json.Marshal(val)
+			return string(encoded), err
+		},
+		"fromJson": func(val string) (interface{}, error) {
+			var decoded interface{}
+			err := json.Unmarshal([]byte(val), &decoded)
+			return decoded, err
+		},
+	}
+}
+
+/*
+Renders a template with access to the other keys of the key space and to the given environment variables, recording the keys it reads
+*/
+func Render(name string, source string, keys map[string]string, hostname string, env map[string]string) (*Template, error) {
+	deps := Dependencies{
+		Keys:     map[string]bool{},
+		Prefixes: map[string]bool{},
+	}
+
+	tmpl, parseErr := template.New(name).Option("missingkey=error").Funcs(getFuncs(hostname, env, keys, &deps)).Parse(source)
+	if parseErr != nil {
+		return nil, errors.New(fmt.Sprintf("Error parsing template %s: %s", name, parseErr.Error()))
+	}
+
+	var output bytes.Buffer
+	execErr := tmpl.Execute(&output, TemplateData{
+		File:     strings.TrimSuffix(name, TemplateSuffix),
+		Hostname: hostname,
+		Env:      env,
+	})
+	if execErr != nil {
+		return nil, errors.New(fmt.Sprintf("Error rendering template %s: %s", name, execErr.Error()))
+	}
+
+	return &Template{
+		Source:       source,
+		Output:       output.String(),
+		Dependencies: deps,
+		Digest:       deps.Digest(keys),
+	}, nil
+}
+
+/*
+Replaces the template keys of a key space with their rendered output, written to a file named after the key without the template suffix.
+Templates that were previously rendered are only rendered again if their source or their dependencies changed.
+Returns the new key space along with the rendered templates, to pass on to the next call.
+*/
+func RenderTemplates(keys map[string]string, previous map[string]*Template, hostname string, env map[string]string) (map[string]string, map[string]*Template, error) {
+	result := map[string]string{}
+	rendered := map[string]*Template{}
+
+	for key, val := range keys {
+		if !strings.HasSuffix(key, TemplateSuffix) || len(key) == len(TemplateSuffix) {
+			result[key] = val
+			continue
+		}
+
+		file := strings.TrimSuffix(key, TemplateSuffix)
+		if _, ok := keys[file]; ok {
+			return result, rendered, errors.New(fmt.Sprintf("Key %s conflicts with the output of template %s", file, key))
+		}
+
+		tmpl, ok := previous[key]
+		if !ok || tmpl.IsStale(val, keys) {
+			var err error
+			tmpl, err = Render(key, val, keys, hostname, env)
+			if err != nil {
+				return result, rendered, err
+			}
+		}
+
+		rendered[key] = tmpl
+		result[file] = tmpl.Output
+	}
+
+	return result, rendered, nil
+}
@@ -0,0 +1,155 @@
+package templates
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	keys := map[string]string{
+		"db/host":  "db.local",
+		"db/port":  "5432",
+		"app.conf": "app",
+	}
+	env := map[string]string{"REGION": "east"}
+
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+		valid    bool
+	}{
+		{"plain text", "static", "static", true},
+		{"key", `{{ key "db/host" }}`, "db.local", true},
+		{"missing key", `{{ key "db/user" }}`, "", false},
+		{"key with a default", `{{ keyOr "db/user" "admin" }}`, "admin", true},
+		{"existing key with a default", `{{ keyOr "db/port" "80" }}`, "5432", true},
+		{"has key", `{{ hasKey "db/host" }} {{ hasKey "db/user" }}`, "true false", true},
+		{"keys under a prefix", `{{ range $k, $v := keys "db/" }}{{ $k }}={{ $v }};{{ end }}`, "host=db.local;port=5432;", true},
+		{"exposed environment variable", `{{ env "REGION" }}`, "east", true},
+		{"exposed environment variable as data", `{{ .Env.REGION }}`, "east", true},
+		{"environment variable that is not exposed", `{{ env "HOME" }}`, "", false},
+		{"hostname and file", `{{ hostname }} {{ .File }}`, "host1 dir/app.conf", true},
+		{"string functions", `{{ "a,b" | split "," | join ";" | upper }}`, "A;B", true},
+		{"invalid syntax", `{{ key "db/host" `, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := Render("dir/app.conf.tmpl", test.source, keys, "host1", env)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("Render(%q) = %q, expected an error", test.source, tmpl.Output)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if tmpl.Output != test.expected {
+				t.Errorf("Render(%q) = %q, expected %q", test.source, tmpl.Output, test.expected)
+			}
+		})
+	}
+}
+
+func TestRenderTemplates(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     map[string]string
+		expected map[string]string
+		valid    bool
+	}{
+		{
+			name:     "templates replaced by their output",
+			keys:     map[string]string{"app.conf.tmpl": `port={{ key "port" }}`, "port": "80"},
+			expected: map[string]string{"app.conf": "port=80", "port": "80"},
+			valid:    true,
+		},
+		{
+			name:     "key named after the suffix only is not a template",
+			keys:     map[string]string{".tmpl": "{{"},
+			expected: map[string]string{".tmpl": "{{"},
+			valid:    true,
+		},
+		{
+			name: "key conflicting with the output of a template",
+			keys: map[string]string{"app.conf.tmpl": "a", "app.conf": "b"},
+		},
+		{
+			name: "template that fails to render",
+			keys: map[string]string{"app.conf.tmpl": `{{ key "missing" }}`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, _, err := RenderTemplates(test.keys, map[string]*Template{}, "host1", map[string]string{})
+			if !test.valid {
+				if err == nil {
+					t.Errorf("RenderTemplates() = %v, expected an error", result)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("RenderTemplates() = %v, expected %v", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestRenderTemplatesReusesUnchangedTemplates(t *testing.T) {
+	keys := map[string]string{
+		"app.conf.tmpl":   `{{ key "port" }}`,
+		"hosts.conf.tmpl": `{{ range $k, $v := keys "hosts/" }}{{ $v }};{{ end }}`,
+		"port":            "80",
+		"hosts/a":         "a",
+		"other":           "other",
+	}
+
+	_, rendered, err := RenderTemplates(keys, map[string]*Template{}, "host1", map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	//An unrelated key changing does not render the templates again
+	keys["other"] = "changed"
+	_, next, err := RenderTemplates(keys, rendered, "host1", map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if next["app.conf.tmpl"] != rendered["app.conf.tmpl"] || next["hosts.conf.tmpl"] != rendered["hosts.conf.tmpl"] {
+		t.Errorf("Expected the templates to be reused")
+	}
+
+	//A key read by a template or added under a prefix it reads renders it again
+	keys["port"] = "8080"
+	keys["hosts/b"] = "b"
+	result, last, err := RenderTemplates(keys, next, "host1", map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if last["app.conf.tmpl"] == next["app.conf.tmpl"] || result["app.conf"] != "8080" {
+		t.Errorf("Expected app.conf to be rendered again, got %q", result["app.conf"])
+	}
+	if last["hosts.conf.tmpl"] == next["hosts.conf.tmpl"] || result["hosts.conf"] != "a;b;" {
+		t.Errorf("Expected hosts.conf to be rendered again, got %q", result["hosts.conf"])
+	}
+}
+
+func TestGetEnv(t *testing.T) {
+	t.Setenv("TEMPLATES_TEST_SET", "value")
+
+	env := GetEnv([]string{"TEMPLATES_TEST_SET", "TEMPLATES_TEST_UNSET"})
+	expected := map[string]string{"TEMPLATES_TEST_SET": "value", "TEMPLATES_TEST_UNSET": ""}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("GetEnv() = %v, expected %v", env, expected)
+	}
+}
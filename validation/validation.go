@@ -0,0 +1,159 @@
+package validation
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	PolicyRejectDiff = "reject_diff"
+	PolicyRejectFile = "reject_file"
+)
+
+type validator func(content []byte) error
+
+var validators = map[string]validator{
+	".json": validateJson,
+	".yaml": validateYaml,
+	".yml":  validateYaml,
+	".toml": validateToml,
+	".xml":  validateXml,
+	".pem":  validatePem,
+	".crt":  validatePem,
+	".cert": validatePem,
+	".key":  validatePem,
+}
+
+func validateJson(content []byte) error {
+	var parsed interface{}
+	return json.Unmarshal(content, &parsed)
+}
+
+func validateYaml(content []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var parsed interface{}
+		err := decoder.Decode(&parsed)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func validateToml(content []byte) error {
+	var parsed map[string]interface{}
+	_, err := toml.Decode(string(content), &parsed)
+	return err
+}
+
+func validateXml(content []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	hasRoot := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, ok := token.(xml.StartElement); ok {
+			hasRoot = true
+		}
+	}
+
+	if !hasRoot {
+		return errors.New("Document has no root element")
+	}
+
+	return nil
+}
+
+func validatePemBlock(block *pem.Block) error {
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		_, err = x509.ParseCertificate(block.Bytes)
+	case "PRIVATE KEY":
+		_, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		_, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		_, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		_, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE REQUEST":
+		_, err = x509.ParseCertificateRequest(block.Bytes)
+	}
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Invalid %s block: %s", block.Type, err.Error()))
+	}
+
+	return nil
+}
+
+func validatePem(content []byte) error {
+	count := 0
+	rest := content
+	for {
+		block, remainder := pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		err := validatePemBlock(block)
+		if err != nil {
+			return err
+		}
+
+		count++
+		rest = remainder
+	}
+
+	if count == 0 {
+		return errors.New("No PEM block found")
+	}
+
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return errors.New("Unexpected content after the last PEM block")
+	}
+
+	return nil
+}
+
+/*
+Checks that the content of a file is valid for the format its extension indicates: json, yaml, toml, xml or pem (.pem, .crt, .cert and .key extensions).
+Certificates and keys in pem files are parsed as well.
+Files with other extensions are always valid.
+*/
+func ValidateFile(file string, content string) error {
+	validate, ok := validators[strings.ToLower(path.Ext(file))]
+	if !ok {
+		return nil
+	}
+
+	err := validate([]byte(content))
+	if err != nil {
+		return errors.New(fmt.Sprintf("File %s is not valid: %s", file, err.Error()))
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package validation
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func generatePem(t *testing.T) (string, string) {
+	privateKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(nil, &template, &template, privateKey.Public(), privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})), string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}))
+}
+
+func TestValidateFile(t *testing.T) {
+	cert, key := generatePem(t)
+	corruptedCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("not a certificate")}))
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		valid   bool
+	}{
+		{"valid json", "app.json", `{"a": [1, 2]}`, true},
+		{"invalid json", "app.json", `{"a": `, false},
+		{"valid yaml", "app.yaml", "a:\n  - 1\n", true},
+		{"yml extension", "app.yml", "a: [1", false},
+		{"valid yaml with several documents", "app.yaml", "a: 1\n---\nb: 2\n", true},
+		{"invalid yaml in a later document", "app.yaml", "a: 1\n---\nb: [2\n", false},
+		{"valid toml", "app.toml", "[server]\nport = 80\n", true},
+		{"invalid toml", "app.toml", "[server\n", false},
+		{"valid xml", "app.xml", "<a><b/></a>", true},
+		{"invalid xml", "app.xml", "<a><b></a>", false},
+		{"xml without a root element", "app.xml", "<?xml version=\"1.0\"?>", false},
+		{"extension in upper case", "APP.JSON", "{", false},
+		{"certificate", "server.crt", cert, true},
+		{"private key", "server.key", key, true},
+		{"certificate chain", "chain.pem", cert + cert, true},
+		{"certificate that does not parse", "server.crt", corruptedCert, false},
+		{"no pem block", "server.pem", "not pem", false},
+		{"content after the last pem block", "server.cert", cert + "trailing", false},
+		{"unvalidated extension", "app.conf", "{", true},
+		{"no extension", "app", "{", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateFile(test.file, test.content)
+			if test.valid && err != nil {
+				t.Errorf("ValidateFile(%q) returned unexpected error: %s", test.file, err.Error())
+			}
+
+			if !test.valid && err == nil {
+				t.Errorf("ValidateFile(%q) returned no error, expected one", test.file)
+			}
+		})
+	}
+}
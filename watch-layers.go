@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+/*
+Key spaces of an ordered list of etcd prefixes, relative to their prefix.
+The keys of later layers shadow the keys of earlier layers with the same path.
+*/
+type LayeredKeys struct {
+	Layers []map[string]string
+}
+
+/*
+Returns the merged key space of the layers.
+As it is recomputed from all the layers, a key deleted from an upper layer reveals the value of a lower layer again.
+*/
+func (l *LayeredKeys) Merge() map[string]string {
+	merged := map[string]string{}
+	for _, layer := range l.Layers {
+		for key, val := range layer {
+			merged[key] = val
+		}
+	}
+
+	return merged
+}
+
+func getLayersWithRetries(cli *client.EtcdClient, prefixes []string, retries uint64) (LayeredKeys, int64, error) {
+	ctx, cancel := context.WithTimeout(cli.Context, cli.RequestTimeout)
+	defer cancel()
+
+	ops := []clientv3.Op{}
+	for _, prefix := range prefixes {
+		ops = append(ops, clientv3.OpGet(prefix, clientv3.WithPrefix()))
+	}
+
+	res, err := cli.Client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		if retries == 0 || !client.ErrorIsRetryable(err) {
+			return LayeredKeys{}, -1, err
+		}
+
+		time.Sleep(cli.RetryInterval)
+		return getLayersWithRetries(cli, prefixes, retries-1)
+	}
+
+	layers := LayeredKeys{Layers: []map[string]string{}}
+	for idx, opRes := range res.Responses {
+		layer := map[string]string{}
+		for _, kv := range opRes.GetResponseRange().Kvs {
+			layer[strings.TrimPrefix(string(kv.Key), prefixes[idx])] = string(kv.Value)
+		}
+		layers.Layers = append(layers.Layers, layer)
+	}
+
+	return layers, res.Header.Revision, nil
+}
+
+/*
+Reads the key spaces of the prefixes of all the layers in a single transaction, so that they are all read at the same revision.
+Returns the layers along with that revision.
+*/
+func GetLayers(cli *client.EtcdClient, prefixes []string) (LayeredKeys, int64, error) {
+	return getLayersWithRetries(cli, prefixes, cli.Retries)
+}
+
+/*
+Changes of all the layers at a given etcd revision.
+A transaction that touches the keys of several layers is reported as a single notification so that the layers are never merged in a state etcd was never in.
+*/
+type LayersWatchNotification struct {
+	Revision int64
+	//Changes of each layer, in the order of the layers. Layers that were not changed have empty changes
+	Changes []client.WatchInfo
+	Error   error
+}
+
+/*
+Groups the events of the watches of the layers by revision.
+A revision is complete once all the watches have reported events or progress at or past it, as the events of a watch arrive in revision order.
+*/
+type layersWatchGrouper struct {
+	prefixes []string
+	//Revision up to which each watch has reported all its events
+	progress []int64
+	pending  map[int64][]client.WatchInfo
+}
+
+func newLayersWatchGrouper(prefixes []string, revision int64) *layersWatchGrouper {
+	progress := []int64{}
+	for _, _ = range prefixes {
+		progress = append(progress, revision)
+	}
+
+	return &layersWatchGrouper{
+		prefixes: prefixes,
+		progress: progress,
+		pending:  map[int64][]client.WatchInfo{},
+	}
+}
+
+func (g *layersWatchGrouper) getChanges(revision int64) []client.WatchInfo {
+	changes, ok := g.pending[revision]
+	if !ok {
+		changes = []client.WatchInfo{}
+		for _, _ = range g.prefixes {
+			changes = append(changes, client.WatchInfo{Upserts: map[string]client.WatchKeyInfo{}, Deletions: []string{}})
+		}
+		g.pending[revision] = changes
+	}
+
+	return changes
+}
+
+/*
+Adds the events of a watch response of a layer
+*/
+func (g *layersWatchGrouper) Add(layer int, res clientv3.WatchResponse) {
+	//Only progress notifications guarantee that all the events up to their header revision were sent.
+	//The header revision of other responses can be ahead of events that are still to come when the watch catches up.
+	if res.IsProgressNotify() {
+		if res.Header.Revision > g.progress[layer] {
+			g.progress[layer] = res.Header.Revision
+		}
+		return
+	}
+
+	for _, ev := range res.Events {
+		revision := ev.Kv.ModRevision
+		changes := g.getChanges(revision)
+		key := strings.TrimPrefix(string(ev.Kv.Key), g.prefixes[layer])
+		if ev.Type == mvccpb.DELETE {
+			changes[layer].Deletions = append(changes[layer].Deletions, key)
+		} else if ev.Type == mvccpb.PUT {
+			changes[layer].Upserts[key] = client.WatchKeyInfo{
+				Value:          string(ev.Kv.Value),
+				Version:        ev.Kv.Version,
+				CreateRevision: ev.Kv.CreateRevision,
+				ModRevision:    ev.Kv.ModRevision,
+				Lease:          ev.Kv.Lease,
+			}
+		}
+
+		if revision > g.progress[layer] {
+			g.progress[layer] = revision
+		}
+	}
+}
+
+/*
+Returns true if some events are held until the other watches report progress
+*/
+func (g *layersWatchGrouper) IsWaiting() bool {
+	return len(g.pending) > 0
+}
+
+/*
+Removes and returns the notifications of the revisions that are complete, in revision order
+*/
+func (g *layersWatchGrouper) Pop() []LayersWatchNotification {
+	revisions := []int64{}
+	for revision, _ := range g.pending {
+		revisions = append(revisions, revision)
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i] < revisions[j] })
+
+	notifications := []LayersWatchNotification{}
+	for _, revision := range revisions {
+		for _, progress := range g.progress {
+			if progress < revision {
+				return notifications
+			}
+		}
+
+		notifications = append(notifications, LayersWatchNotification{Revision: revision, Changes: g.pending[revision]})
+		delete(g.pending, revision)
+	}
+
+	return notifications
+}
+
+const progressRequestInterval = time.Second
+
+type layerWatchResponse struct {
+	Layer    int
+	Response clientv3.WatchResponse
+	Closed   bool
+}
+
+/*
+Watches the prefixes of all the layers from the revision following the one they were read at and reports their changes grouped by revision in a single channel.
+Each layer has its own watch and the events of a revision are held until all the watches have caught up with it, requesting progress notifications from etcd if needed.
+The channel is closed once the context is cancelled or after an error is reported.
+*/
+func WatchLayers(ctx context.Context, cli *client.EtcdClient, prefixes []string, revision int64) <-chan LayersWatchNotification {
+	outChan := make(chan LayersWatchNotification)
+
+	go func() {
+		//The watches share the context so that they share the same stream, which progress requests apply to
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer close(outChan)
+
+		resChan := make(chan layerWatchResponse)
+		for idx, prefix := range prefixes {
+			wc := cli.Client.Watch(watchCtx, prefix, clientv3.WithPrefix(), clientv3.WithRev(revision+1), clientv3.WithProgressNotify())
+			go func(layer int, wc clientv3.WatchChan) {
+				for res := range wc {
+					select {
+					case resChan <- layerWatchResponse{Layer: layer, Response: res}:
+					case <-watchCtx.Done():
+						return
+					}
+				}
+
+				select {
+				case resChan <- layerWatchResponse{Layer: layer, Closed: true}:
+				case <-watchCtx.Done():
+				}
+			}(idx, wc)
+		}
+
+		sendError := func(err error) {
+			select {
+			case outChan <- LayersWatchNotification{Error: err}:
+			case <-watchCtx.Done():
+			}
+		}
+
+		grouper := newLayersWatchGrouper(prefixes, revision)
+		//Progress is requested again periodically as etcd ignores the requests made while a watch is still catching up
+		ticker := time.NewTicker(progressRequestInterval)
+		defer ticker.Stop()
+
+		for {
+			waiting := grouper.IsWaiting()
+
+			select {
+			case res := <-resChan:
+				if res.Closed {
+					if watchCtx.Err() == nil {
+						sendError(errors.New(fmt.Sprintf("Failed to watch changes: Watch of prefix %s stopped", prefixes[res.Layer])))
+					}
+					return
+				}
+
+				err := res.Response.Err()
+				if err != nil {
+					sendError(errors.New(fmt.Sprintf("Failed to watch changes: %s", err.Error())))
+					return
+				}
+
+				grouper.Add(res.Layer, res.Response)
+			case <-ticker.C:
+				if waiting {
+					cli.Client.RequestProgress(watchCtx)
+				}
+				continue
+			case <-watchCtx.Done():
+				return
+			}
+
+			for _, notification := range grouper.Pop() {
+				select {
+				case outChan <- notification:
+				case <-watchCtx.Done():
+					return
+				}
+			}
+
+			if !waiting && grouper.IsWaiting() {
+				cli.Client.RequestProgress(watchCtx)
+			}
+		}
+	}()
+
+	return outChan
+}
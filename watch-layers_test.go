@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestLayeredKeysMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		layers   []map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "no layers",
+			layers:   []map[string]string{},
+			expected: map[string]string{},
+		},
+		{
+			name:     "single layer",
+			layers:   []map[string]string{{"a": "base"}},
+			expected: map[string]string{"a": "base"},
+		},
+		{
+			name: "later layers shadow earlier ones",
+			layers: []map[string]string{
+				{"a": "base", "b": "base", "c": "base"},
+				{"a": "team", "b": "team"},
+				{"a": "site"},
+			},
+			expected: map[string]string{"a": "site", "b": "team", "c": "base"},
+		},
+		{
+			name: "key missing from an upper layer reveals the lower layer",
+			layers: []map[string]string{
+				{"a": "base"},
+				{},
+			},
+			expected: map[string]string{"a": "base"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			layers := LayeredKeys{Layers: test.layers}
+			merged := layers.Merge()
+			if !reflect.DeepEqual(merged, test.expected) {
+				t.Errorf("Merge() = %v, expected %v", merged, test.expected)
+			}
+		})
+	}
+}
+
+func putEvent(key string, value string, revision int64) *clientv3.Event {
+	return &clientv3.Event{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: revision}}
+}
+
+func deleteEvent(key string, revision int64) *clientv3.Event {
+	return &clientv3.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte(key), ModRevision: revision}}
+}
+
+func progressNotify(revision int64) clientv3.WatchResponse {
+	return clientv3.WatchResponse{Header: etcdserverpb.ResponseHeader{Revision: revision}}
+}
+
+func TestLayersWatchGrouper(t *testing.T) {
+	grouper := newLayersWatchGrouper([]string{"/base/", "/site/"}, 10)
+
+	//A transaction touching both layers at revision 11, reported by the watch of the first layer only so far
+	grouper.Add(0, clientv3.WatchResponse{Header: etcdserverpb.ResponseHeader{Revision: 11}, Events: []*clientv3.Event{putEvent("/base/a", "base", 11)}})
+	if notifications := grouper.Pop(); len(notifications) != 0 || !grouper.IsWaiting() {
+		t.Fatalf("Expected revision 11 to be held until the second layer catches up, got %v", notifications)
+	}
+
+	grouper.Add(1, clientv3.WatchResponse{Header: etcdserverpb.ResponseHeader{Revision: 12}, Events: []*clientv3.Event{deleteEvent("/site/a", 11), putEvent("/site/b", "site", 12)}})
+	notifications := grouper.Pop()
+	expected := []LayersWatchNotification{
+		{
+			Revision: 11,
+			Changes: []client.WatchInfo{
+				{Upserts: map[string]client.WatchKeyInfo{"a": {Value: "base", ModRevision: 11}}, Deletions: []string{}},
+				{Upserts: map[string]client.WatchKeyInfo{}, Deletions: []string{"a"}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(notifications, expected) {
+		t.Fatalf("Pop() = %v, expected %v", notifications, expected)
+	}
+
+	//Revision 12 only touches the second layer, but the first one could still report events at that revision
+	if notifications := grouper.Pop(); len(notifications) != 0 {
+		t.Fatalf("Expected revision 12 to be held until the first layer reports progress, got %v", notifications)
+	}
+
+	grouper.Add(0, progressNotify(12))
+	notifications = grouper.Pop()
+	expected = []LayersWatchNotification{
+		{
+			Revision: 12,
+			Changes: []client.WatchInfo{
+				{Upserts: map[string]client.WatchKeyInfo{}, Deletions: []string{}},
+				{Upserts: map[string]client.WatchKeyInfo{"b": {Value: "site", ModRevision: 12}}, Deletions: []string{}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(notifications, expected) {
+		t.Fatalf("Pop() = %v, expected %v", notifications, expected)
+	}
+
+	if grouper.IsWaiting() {
+		t.Errorf("Expected no revision to be held once all were popped")
+	}
+}